@@ -0,0 +1,95 @@
+// Package api serves the indexed data over a read-only HTTP API.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/events"
+	"gorm.io/gorm"
+)
+
+// shutdownTimeout is how long requests in flight are waited for when the server stops
+const shutdownTimeout = 5 * time.Second
+
+// Server routes API requests, endpoints of other packages are added with Handle.
+type Server struct {
+	db    *gorm.DB
+	pools []config.Pool
+	bus   *events.Bus
+	mux   *http.ServeMux
+}
+
+// NewServer returns the API over the indexed users and logs of the pools and the events of the default bus.
+func NewServer(db *gorm.DB, pools []config.Pool) *Server {
+	s := &Server{db: db, pools: pools, bus: events.Default, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("GET /users/{wallet}", s.getUsers)
+	s.mux.HandleFunc("GET /users/{wallet}/history", s.getUserHistory)
+	s.mux.HandleFunc("GET /pools/{pool}/logs", s.getPoolLogs)
+	s.mux.HandleFunc("GET /pools/{pool}/sync-state", s.getSyncState)
+	s.mux.HandleFunc("GET /events", s.getEvents)
+
+	graphqlHandler := s.graphqlHandler()
+	s.mux.Handle("POST /graphql", graphqlHandler)
+	s.mux.Handle("GET /graphql", graphqlHandler)
+
+	return s
+}
+
+// Handle registers the handler for the pattern, see http.ServeMux.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API at the address until the context is canceled.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	srv := &http.Server{Addr: address, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error per serving api at %s: %w", address, err)
+	}
+	return nil
+}
+
+func (s *Server) pool(name string) (config.Pool, bool) {
+	for _, pool := range s.pools {
+		if pool.Name == name {
+			return pool, true
+		}
+	}
+	return config.Pool{}, false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("error per writing api response", "status", status, "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusInternalServerError {
+		slog.Error("api error", "err", err)
+		err = errors.New("internal error")
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/migrations"
+	"github.com/xssnick/tonutils-go/address"
+	"gorm.io/gorm"
+)
+
+// testDB connects to the database from ../config.yaml, tests are skipped when it is not available.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	cfg, err := config.LoadConfig("../config.yaml")
+	if err != nil {
+		t.Skipf("no test config: %v", err)
+	}
+	config.CFG = cfg
+
+	db, err := config.GetDBInstance()
+	if err != nil {
+		t.Skipf("database is not available: %v", err)
+	}
+
+	if _, err := migrations.Up(db, migrations.Options{}); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+
+	return db
+}
+
+func get(t *testing.T, handler http.Handler, url string, v interface{}) int {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: cannot decode %s: %v", url, rec.Body, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestParseWallet(t *testing.T) {
+	friendly, err := parseWallet("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
+	if err != nil {
+		t.Fatalf("parseWallet: %v", err)
+	}
+	raw, err := parseWallet(address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa").StringRaw())
+	if err != nil {
+		t.Fatalf("parseWallet: %v", err)
+	}
+	if friendly != raw {
+		t.Errorf("want the same address, got %s and %s", friendly, raw)
+	}
+	if _, err := parseWallet("wallet"); err == nil {
+		t.Error("want an error for an invalid address")
+	}
+}
+
+func TestLogCursor(t *testing.T) {
+	c := logCursor{Utime: 1700000000, Lt: 100, Pool: "main", Hash: "h1", MsgIndex: 2}
+	parsed, err := parseLogCursor(c.String())
+	if err != nil {
+		t.Fatalf("parseLogCursor: %v", err)
+	}
+	if *parsed != c {
+		t.Errorf("want %+v, got %+v", c, *parsed)
+	}
+	if _, err := parseLogCursor("not a cursor"); err == nil {
+		t.Error("want an error for an invalid cursor")
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	server := NewServer(nil, config.Pools)
+
+	for url, status := range map[string]int{
+		"/pools/unknown/logs":       http.StatusNotFound,
+		"/pools/unknown/sync-state": http.StatusNotFound,
+		"/pools/main/logs?limit=0":  http.StatusBadRequest,
+		"/pools/main/logs?from=x":   http.StatusBadRequest,
+		"/pools/main/logs?cursor=x": http.StatusBadRequest,
+		"/users/wallet":             http.StatusBadRequest,
+		"/users/EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa?pool=unknown":         http.StatusNotFound,
+		"/users/EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa/history?subaccount=x": http.StatusBadRequest,
+	} {
+		var body map[string]string
+		if got := get(t, server, url, &body); got != status {
+			t.Errorf("%s: want status %d, got %d", url, status, got)
+		}
+		if body["error"] == "" {
+			t.Errorf("%s: want an error message", url)
+		}
+	}
+}
+
+func TestUserJSON(t *testing.T) {
+	user := newUser(config.OnchainUser{
+		WalletAddress: "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa",
+		Pool:          "main",
+		State:         config.BigInt{Int: big.NewInt(0)},
+		Principals: config.Principals{
+			config.BigInt{Int: big.NewInt(11)}: config.BigInt{Int: big.NewInt(-500)},
+		},
+	})
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	for _, want := range []string{`"state":"0"`, `"principals":{"11":"-500"}`, `"wallet_address":"EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("want %s in %s", want, data)
+		}
+	}
+}
+
+func TestPoolLogsPages(t *testing.T) {
+	db := testDB(t)
+
+	pool := config.Pool{Name: "test_api", Address: config.PoolMain.Address}
+	cleanup := func() {
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainLog{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	for i := 0; i < 5; i++ {
+		// two logs of one transaction share the utime and lt
+		idxLog := config.OnchainLog{
+			Hash:                fmt.Sprintf("H%d", i/2),
+			Pool:                pool.Name,
+			Utime:               int64(1700000000 + i/2),
+			Lt:                  int64(100 + i/2),
+			MsgIndex:            i % 2,
+			TxType:              "supply",
+			SenderAddress:       "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa",
+			UserAddress:         "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa",
+			AttachedAssetAmount: config.BigInt{Int: big.NewInt(int64(i))},
+		}
+		if err := db.Create(&idxLog).Error; err != nil {
+			t.Fatalf("cannot create log: %v", err)
+		}
+	}
+
+	server := NewServer(db, []config.Pool{pool})
+	var logs []Log
+	url := "/pools/test_api/logs?limit=2"
+	for pages := 0; ; pages++ {
+		var page Page[Log]
+		if status := get(t, server, url, &page); status != http.StatusOK {
+			t.Fatalf("%s: status %d", url, status)
+		}
+		logs = append(logs, page.Items...)
+		if page.NextCursor == "" {
+			break
+		}
+		if pages > 5 {
+			t.Fatal("pages do not end")
+		}
+		url = "/pools/test_api/logs?limit=2&cursor=" + page.NextCursor
+	}
+
+	if len(logs) != 5 {
+		t.Fatalf("want 5 logs, got %d", len(logs))
+	}
+	for i := 1; i < len(logs); i++ {
+		if logs[i].Utime > logs[i-1].Utime {
+			t.Errorf("logs are not ordered from the newest: %+v", logs)
+		}
+	}
+	if logs[0].Hash != "H2" || logs[4].Hash != "H0" || logs[4].MsgIndex != 0 {
+		t.Errorf("unexpected order %+v", logs)
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/evaafi/go-indexer/events"
+	"github.com/gorilla/websocket"
+)
+
+// eventsBuffer is how many events a slow client may fall behind before events are dropped
+const eventsBuffer = 256
+
+// eventsKeepAlive is how often an idle stream is written to, so proxies do not close it
+const eventsKeepAlive = 15 * time.Second
+
+// eventMessage is an event sent to clients, data is a Log, User or SyncState.
+type eventMessage struct {
+	Type events.Kind `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+func newEventMessage(e events.Event) eventMessage {
+	msg := eventMessage{Type: e.Kind()}
+	switch e := e.(type) {
+	case events.LogIndexed:
+		msg.Data = newLog(e.Log)
+	case events.UserUpdated:
+		msg.Data = newUser(e.User)
+	case events.CursorAdvanced:
+		msg.Data = SyncState{Pool: e.State.Pool, LastLt: e.State.LastLt, LastHash: e.State.LastHash, LastUtime: e.State.LastUtime}
+	}
+	return msg
+}
+
+// eventFilter reads the pool, wallet, type (tx type of logs) and events (comma separated kinds) parameters.
+func (s *Server) eventFilter(r *http.Request) (events.Filter, int, error) {
+	params := r.URL.Query()
+	filter := events.Filter{TxType: params.Get("type")}
+
+	if name := params.Get("pool"); name != "" {
+		pool, ok := s.pool(name)
+		if !ok {
+			return filter, http.StatusNotFound, fmt.Errorf("unknown pool %s", name)
+		}
+		filter.Pool = pool.Name
+	}
+	if wallet := params.Get("wallet"); wallet != "" {
+		var err error
+		if filter.Wallet, err = parseWallet(wallet); err != nil {
+			return filter, http.StatusBadRequest, err
+		}
+	}
+	if kinds := params.Get("events"); kinds != "" {
+		for _, kind := range strings.Split(kinds, ",") {
+			switch k := events.Kind(kind); k {
+			case events.KindLogIndexed, events.KindUserUpdated, events.KindCursorAdvanced:
+				filter.Kinds = append(filter.Kinds, k)
+			default:
+				return filter, http.StatusBadRequest, fmt.Errorf("unknown event %s", kind)
+			}
+		}
+	}
+
+	return filter, http.StatusOK, nil
+}
+
+// getEvents serves /events?pool=&wallet=&type=&events= as server-sent events, or over WebSocket
+// when the connection is upgraded.
+func (s *Server) getEvents(w http.ResponseWriter, r *http.Request) {
+	filter, status, err := s.eventFilter(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	// events published after the client is connected are not missed
+	sub := s.bus.Subscribe(filter, eventsBuffer)
+	defer sub.Close()
+
+	if websocket.IsWebSocketUpgrade(r) {
+		streamEventsWS(w, r, sub)
+		return
+	}
+	streamEventsSSE(w, r, sub)
+}
+
+func streamEventsSSE(w http.ResponseWriter, r *http.Request, sub *events.Subscription) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case e := <-sub.C:
+			data, err := json.Marshal(newEventMessage(e).Data)
+			if err != nil {
+				slog.Error("error per encoding event", "kind", e.Kind(), "err", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind(), data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func streamEventsWS(w http.ResponseWriter, r *http.Request, sub *events.Subscription) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	// clients only listen, reading detects the closed connection
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+		case e := <-sub.C:
+			err = conn.WriteJSON(newEventMessage(e))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/events"
+	"github.com/gorilla/websocket"
+)
+
+const testWallet = "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa"
+
+func TestEventsSSE(t *testing.T) {
+	server := NewServer(nil, config.Pools)
+	server.bus = events.NewBus()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, httpServer.URL+"/events?pool=main&type=liquidation", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("want an event stream, got %s", resp.Header.Get("Content-Type"))
+	}
+
+	server.bus.Publish(events.LogIndexed{Log: config.OnchainLog{Pool: "main", TxType: "supply", Hash: "skipped"}})
+	server.bus.Publish(events.LogIndexed{Log: config.OnchainLog{Pool: "main", TxType: "liquidation", Hash: "L1"}})
+
+	reader := bufio.NewReader(resp.Body)
+	event, _ := reader.ReadString('\n')
+	data, _ := reader.ReadString('\n')
+	if event != "event: log_indexed\n" || !strings.Contains(data, `"hash":"L1"`) {
+		t.Errorf("want the liquidation log, got %q %q", event, data)
+	}
+}
+
+func TestEventsWS(t *testing.T) {
+	server := NewServer(nil, config.Pools)
+	server.bus = events.NewBus()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/events?wallet=" + testWallet + "&events=user_updated"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	server.bus.Publish(events.CursorAdvanced{State: config.OnchainSyncState{Pool: "main"}})
+	server.bus.Publish(events.UserUpdated{User: config.OnchainUser{Pool: "main", WalletAddress: testWallet}})
+
+	var msg struct {
+		Type events.Kind `json:"type"`
+		Data User        `json:"data"`
+	}
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if msg.Type != events.KindUserUpdated || msg.Data.WalletAddress != testWallet {
+		t.Errorf("want the user update, got %+v", msg)
+	}
+}
+
+func TestEventsBadRequests(t *testing.T) {
+	server := NewServer(nil, config.Pools)
+	for url, status := range map[string]int{
+		"/events?pool=unknown": http.StatusNotFound,
+		"/events?wallet=x":     http.StatusBadRequest,
+		"/events?events=x":     http.StatusBadRequest,
+	} {
+		if got := get(t, server, url, nil); got != status {
+			t.Errorf("%s: want status %d, got %d", url, status, got)
+		}
+	}
+}
+
+func TestGraphQLLogIndexed(t *testing.T) {
+	server := NewServer(nil, config.Pools)
+	server.bus = events.NewBus()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{graphqlWSProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/graphql", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var reply wsMessage
+	_ = conn.WriteJSON(wsMessage{Type: "connection_init"})
+	if err := conn.ReadJSON(&reply); err != nil || reply.Type != "connection_ack" {
+		t.Fatalf("want connection_ack, got %+v %v", reply, err)
+	}
+	payload, _ := json.Marshal(wsSubscribePayload{Query: `subscription { logIndexed(filter: {subaccount: 1}) { hash subaccount } }`})
+	_ = conn.WriteJSON(wsMessage{ID: "1", Type: "subscribe", Payload: payload})
+
+	// the subscription starts asynchronously, logs are published until one is received
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			server.bus.Publish(events.LogIndexed{Log: config.OnchainLog{Pool: "main", Hash: "other"}})
+			server.bus.Publish(events.LogIndexed{Log: config.OnchainLog{Pool: "main", Hash: "S1", SubaccountID: 1}})
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if reply.Type != "next" || reply.ID != "1" || string(reply.Payload) != `{"data":{"logIndexed":{"hash":"S1","subaccount":1}}}` {
+		t.Errorf("want the subaccount log, got %s %s", reply.Type, reply.Payload)
+	}
+}
+
+func TestGraphQLWSDisconnect(t *testing.T) {
+	server := NewServer(nil, config.Pools)
+	server.bus = events.NewBus()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{graphqlWSProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/graphql", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var reply wsMessage
+	_ = conn.WriteJSON(wsMessage{Type: "connection_init"})
+	if err := conn.ReadJSON(&reply); err != nil || reply.Type != "connection_ack" {
+		t.Fatalf("want connection_ack, got %+v %v", reply, err)
+	}
+	// no log is ever published for the filter
+	payload, _ := json.Marshal(wsSubscribePayload{Query: `subscription { logIndexed(filter: {subaccount: 7}) { hash } }`})
+	_ = conn.WriteJSON(wsMessage{ID: "1", Type: "subscribe", Payload: payload})
+
+	waitSubscriptions := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for server.bus.Subscriptions() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("want %d subscriptions, got %d", want, server.bus.Subscriptions())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitSubscriptions(1)
+
+	// the client goes away without completing the operation
+	_ = conn.Close()
+	waitSubscriptions(0)
+}
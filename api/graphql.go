@@ -0,0 +1,439 @@
+package api
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/events"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+	"gorm.io/gorm"
+)
+
+//go:embed schema.graphql
+var graphqlSchema string
+
+// graphqlMaxDepth limits nesting of queries, user → logs is the deepest path of the schema
+const graphqlMaxDepth = 10
+
+// graphqlHandler serves queries posted to /graphql and subscriptions over WebSocket, see serveGraphQLWS.
+func (s *Server) graphqlHandler() http.Handler {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{s: s}, graphql.MaxDepth(graphqlMaxDepth))
+	post := &relay.Handler{Schema: schema}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			serveGraphQLWS(w, r, schema)
+			return
+		}
+		post.ServeHTTP(w, r)
+	})
+}
+
+// bigInt is the BigInt scalar.
+type bigInt struct {
+	config.BigInt
+}
+
+func (bigInt) ImplementsGraphQLType(name string) bool {
+	return name == "BigInt"
+}
+
+func (b *bigInt) UnmarshalGraphQL(input interface{}) error {
+	switch v := input.(type) {
+	case string:
+		i, ok := new(big.Int).SetString(v, 10)
+		if !ok {
+			return fmt.Errorf("BigInt: cannot parse %q", v)
+		}
+		b.Int = i
+	case int32:
+		b.Int = big.NewInt(int64(v))
+	default:
+		return fmt.Errorf("BigInt: unsupported input %T", input)
+	}
+	return nil
+}
+
+func optionalBigInt(b config.BigInt) *bigInt {
+	if b.Int == nil {
+		return nil
+	}
+	return &bigInt{b}
+}
+
+type logFilterInput struct {
+	Pool       *string
+	Wallet     *string
+	Subaccount *int32
+	TxType     *string
+	TxSubType  *string
+	Asset      *bigInt
+	FromUtime  *int32
+	ToUtime    *int32
+}
+
+type logsArgs struct {
+	Filter *logFilterInput
+	First  int32
+	After  *string
+}
+
+// normalize checks the pool and the wallet of the filter and returns the filter with the wallet
+// in the form addresses are indexed in.
+func (f *logFilterInput) normalize(s *Server) (logFilterInput, error) {
+	var filter logFilterInput
+	if f != nil {
+		filter = *f
+	}
+
+	if filter.Pool != nil {
+		if _, ok := s.pool(*filter.Pool); !ok {
+			return filter, fmt.Errorf("unknown pool %s", *filter.Pool)
+		}
+	}
+	if filter.Wallet != nil {
+		wallet, err := parseWallet(*filter.Wallet)
+		if err != nil {
+			return filter, err
+		}
+		filter.Wallet = &wallet
+	}
+	return filter, nil
+}
+
+// matches reports whether the log is selected by the normalized filter.
+func (f logFilterInput) matches(l config.OnchainLog) bool {
+	switch {
+	case f.Pool != nil && *f.Pool != l.Pool,
+		f.Wallet != nil && *f.Wallet != l.UserAddress,
+		f.Subaccount != nil && *f.Subaccount != int32(l.SubaccountID),
+		f.TxType != nil && *f.TxType != l.TxType,
+		f.TxSubType != nil && *f.TxSubType != l.TxSubType,
+		f.FromUtime != nil && l.Utime < int64(*f.FromUtime),
+		f.ToUtime != nil && l.Utime >= int64(*f.ToUtime):
+		return false
+	}
+	if f.Asset != nil {
+		return sameBigInt(f.Asset.BigInt, l.AttachedAssetAddress) || sameBigInt(f.Asset.BigInt, l.RedeemedAssetAddress)
+	}
+	return true
+}
+
+func sameBigInt(a, b config.BigInt) bool {
+	return a.Int != nil && b.Int != nil && a.Cmp(b.Int) == 0
+}
+
+// logScope returns a scope selecting logs of the filter.
+func (s *Server) logScope(f *logFilterInput) (func(*gorm.DB) *gorm.DB, error) {
+	filter, err := f.normalize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(query *gorm.DB) *gorm.DB {
+		if filter.Pool != nil {
+			query = query.Where("pool = ?", *filter.Pool)
+		}
+		if filter.Wallet != nil {
+			query = query.Where("user_address = ?", *filter.Wallet)
+		}
+		if filter.Subaccount != nil {
+			query = query.Where("subaccount_id = ?", *filter.Subaccount)
+		}
+		if filter.Asset != nil {
+			asset := filter.Asset.String()
+			query = query.Where("(attached_asset_address = ? OR redeemed_asset_address = ?)", asset, asset)
+		}
+		if filter.TxType != nil {
+			query = query.Where("tx_type = ?", *filter.TxType)
+		}
+		if filter.TxSubType != nil {
+			query = query.Where("tx_sub_type = ?", *filter.TxSubType)
+		}
+		if filter.FromUtime != nil {
+			query = query.Where("utime >= ?", *filter.FromUtime)
+		}
+		if filter.ToUtime != nil {
+			query = query.Where("utime < ?", *filter.ToUtime)
+		}
+		return query
+	}, nil
+}
+
+// logs returns a page of logs selected by the args and the scope, newest first.
+func (s *Server) logs(args logsArgs, scope func(*gorm.DB) *gorm.DB) (*logConnection, error) {
+	if args.First < 1 || args.First > maxLimit {
+		return nil, fmt.Errorf("first must be between 1 and %d", maxLimit)
+	}
+	q := logQuery{limit: int(args.First)}
+	if args.After != nil {
+		var err error
+		if q.cursor, err = parseLogCursor(*args.After); err != nil {
+			return nil, err
+		}
+	}
+
+	page, err := q.find(s.db.Model(&config.OnchainLog{}).Scopes(scope))
+	if err != nil {
+		return nil, err
+	}
+	return &logConnection{page: page}, nil
+}
+
+type graphqlResolver struct {
+	s *Server
+}
+
+func (r *graphqlResolver) Users(args struct {
+	Wallet     string
+	Pool       *string
+	Subaccount *int32
+}) ([]*userResolver, error) {
+	wallet, err := parseWallet(args.Wallet)
+	if err != nil {
+		return nil, err
+	}
+	var pool config.Pool
+	if args.Pool != nil {
+		var ok bool
+		if pool, ok = r.s.pool(*args.Pool); !ok {
+			return nil, fmt.Errorf("unknown pool %s", *args.Pool)
+		}
+	}
+
+	query := r.s.db.Where("wallet_address = ?", wallet)
+	if pool.Name != "" {
+		query = query.Where("pool = ?", pool.Name)
+	}
+	if args.Subaccount != nil {
+		query = query.Where("subaccount_id = ?", *args.Subaccount)
+	}
+
+	var users []config.OnchainUser
+	if err := query.Order("pool, subaccount_id").Find(&users).Error; err != nil {
+		slog.Error("api error", "query", "users", "err", err)
+		return nil, errors.New("error per reading users")
+	}
+
+	resolvers := make([]*userResolver, 0, len(users))
+	for _, u := range users {
+		resolvers = append(resolvers, &userResolver{s: r.s, u: u})
+	}
+	return resolvers, nil
+}
+
+func (r *graphqlResolver) Logs(args logsArgs) (*logConnection, error) {
+	scope, err := r.s.logScope(args.Filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.s.logs(args, scope)
+}
+
+func (r *graphqlResolver) SyncStates(args struct{ Pool *string }) ([]*syncStateResolver, error) {
+	var pool config.Pool
+	if args.Pool != nil {
+		var ok bool
+		if pool, ok = r.s.pool(*args.Pool); !ok {
+			return nil, fmt.Errorf("unknown pool %s", *args.Pool)
+		}
+	}
+
+	query := r.s.db.Order("pool")
+	if pool.Name != "" {
+		query = query.Where("pool = ?", pool.Name)
+	}
+
+	var states []config.OnchainSyncState
+	if err := query.Find(&states).Error; err != nil {
+		slog.Error("api error", "query", "syncStates", "pool", pool.Name, "err", err)
+		return nil, errors.New("error per reading sync states")
+	}
+
+	resolvers := make([]*syncStateResolver, 0, len(states))
+	for _, state := range states {
+		resolvers = append(resolvers, &syncStateResolver{state})
+	}
+	return resolvers, nil
+}
+
+// LogIndexed sends logs of the filter published to the event bus.
+func (r *graphqlResolver) LogIndexed(ctx context.Context, args struct{ Filter *logFilterInput }) (<-chan *logResolver, error) {
+	filter, err := args.Filter.normalize(r.s)
+	if err != nil {
+		return nil, err
+	}
+	busFilter := events.Filter{Kinds: []events.Kind{events.KindLogIndexed}}
+	if filter.Pool != nil {
+		busFilter.Pool = *filter.Pool
+	}
+	if filter.Wallet != nil {
+		busFilter.Wallet = *filter.Wallet
+	}
+	sub := r.s.bus.Subscribe(busFilter, eventsBuffer)
+
+	logs := make(chan *logResolver)
+	go func() {
+		defer close(logs)
+		defer sub.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-sub.C:
+				indexed, ok := e.(events.LogIndexed)
+				if !ok || !filter.matches(indexed.Log) {
+					continue
+				}
+				select {
+				case logs <- &logResolver{newLog(indexed.Log)}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return logs, nil
+}
+
+type userResolver struct {
+	s *Server
+	u config.OnchainUser
+}
+
+func (r *userResolver) WalletAddress() string   { return r.u.WalletAddress }
+func (r *userResolver) Pool() string            { return r.u.Pool }
+func (r *userResolver) Subaccount() int32       { return int32(r.u.SubaccountID) }
+func (r *userResolver) ContractAddress() string { return r.u.ContractAddress }
+func (r *userResolver) CodeVersion() int32      { return int32(r.u.CodeVersion) }
+func (r *userResolver) State() bigInt           { return bigInt{r.u.State} }
+func (r *userResolver) CreatedAt() graphql.Time { return graphql.Time{Time: r.u.CreatedAt} }
+func (r *userResolver) UpdatedAt() graphql.Time { return graphql.Time{Time: r.u.UpdatedAt} }
+
+func (r *userResolver) Principals() []*principalResolver {
+	principals := make([]*principalResolver, 0, len(r.u.Principals))
+	for asset, principal := range r.u.Principals {
+		principals = append(principals, &principalResolver{asset: asset, principal: principal})
+	}
+	return principals
+}
+
+func (r *userResolver) Logs(args logsArgs) (*logConnection, error) {
+	filter := logFilterInput{}
+	if args.Filter != nil {
+		filter = *args.Filter
+	}
+	subaccount := int32(r.u.SubaccountID)
+	filter.Pool, filter.Wallet, filter.Subaccount = &r.u.Pool, &r.u.WalletAddress, &subaccount
+
+	scope, err := r.s.logScope(&filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.s.logs(args, scope)
+}
+
+type principalResolver struct {
+	asset, principal config.BigInt
+}
+
+func (r *principalResolver) Asset() bigInt     { return bigInt{r.asset} }
+func (r *principalResolver) Principal() bigInt { return bigInt{r.principal} }
+
+type logConnection struct {
+	page Page[Log]
+}
+
+func (c *logConnection) Items() []*logResolver {
+	items := make([]*logResolver, 0, len(c.page.Items))
+	for _, l := range c.page.Items {
+		items = append(items, &logResolver{l})
+	}
+	return items
+}
+
+func (c *logConnection) NextCursor() *string {
+	if c.page.NextCursor == "" {
+		return nil
+	}
+	return &c.page.NextCursor
+}
+
+type logResolver struct {
+	l Log
+}
+
+func (r *logResolver) Hash() string          { return r.l.Hash }
+func (r *logResolver) Pool() string          { return r.l.Pool }
+func (r *logResolver) Lt() bigInt            { return bigInt{config.BigInt{Int: big.NewInt(r.l.Lt)}} }
+func (r *logResolver) MsgIndex() int32       { return int32(r.l.MsgIndex) }
+func (r *logResolver) Utime() int32          { return int32(r.l.Utime) }
+func (r *logResolver) TxType() string        { return r.l.TxType }
+func (r *logResolver) TxSubType() string     { return r.l.TxSubType }
+func (r *logResolver) LogVersion() int32     { return int32(r.l.LogVersion) }
+func (r *logResolver) SenderAddress() string { return r.l.SenderAddress }
+func (r *logResolver) UserAddress() string   { return r.l.UserAddress }
+func (r *logResolver) Subaccount() int32     { return int32(r.l.SubaccountID) }
+
+func (r *logResolver) AttachedAssetAddress() *bigInt {
+	return optionalBigInt(r.l.AttachedAssetAddress)
+}
+func (r *logResolver) AttachedAssetAmount() *bigInt {
+	return optionalBigInt(r.l.AttachedAssetAmount)
+}
+func (r *logResolver) AttachedAssetPrincipal() *bigInt {
+	return optionalBigInt(r.l.AttachedAssetPrincipal)
+}
+func (r *logResolver) AttachedAssetTotalSupplyPrincipal() *bigInt {
+	return optionalBigInt(r.l.AttachedAssetTotalSupplyPrincipal)
+}
+func (r *logResolver) AttachedAssetTotalBorrowPrincipal() *bigInt {
+	return optionalBigInt(r.l.AttachedAssetTotalBorrowPrincipal)
+}
+func (r *logResolver) AttachedAssetSRate() *bigInt {
+	return optionalBigInt(r.l.AttachedAssetSRate)
+}
+func (r *logResolver) AttachedAssetBRate() *bigInt {
+	return optionalBigInt(r.l.AttachedAssetBRate)
+}
+func (r *logResolver) RedeemedAssetAddress() *bigInt {
+	return optionalBigInt(r.l.RedeemedAssetAddress)
+}
+func (r *logResolver) RedeemedAssetAmount() *bigInt {
+	return optionalBigInt(r.l.RedeemedAssetAmount)
+}
+func (r *logResolver) RedeemedAssetPrincipal() *bigInt {
+	return optionalBigInt(r.l.RedeemedAssetPrincipal)
+}
+func (r *logResolver) RedeemedAssetTotalSupplyPrincipal() *bigInt {
+	return optionalBigInt(r.l.RedeemedAssetTotalSupplyPrincipal)
+}
+func (r *logResolver) RedeemedAssetTotalBorrowPrincipal() *bigInt {
+	return optionalBigInt(r.l.RedeemedAssetTotalBorrowPrincipal)
+}
+func (r *logResolver) RedeemedAssetSRate() *bigInt {
+	return optionalBigInt(r.l.RedeemedAssetSRate)
+}
+func (r *logResolver) RedeemedAssetBRate() *bigInt {
+	return optionalBigInt(r.l.RedeemedAssetBRate)
+}
+
+type syncStateResolver struct {
+	state config.OnchainSyncState
+}
+
+func (r *syncStateResolver) Pool() string { return r.state.Pool }
+func (r *syncStateResolver) LastLt() bigInt {
+	return bigInt{config.BigInt{Int: big.NewInt(r.state.LastLt)}}
+}
+func (r *syncStateResolver) LastHash() string { return r.state.LastHash }
+func (r *syncStateResolver) LastUtime() int32 { return int32(r.state.LastUtime) }
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/gorilla/websocket"
+)
+
+func TestGraphQLErrors(t *testing.T) {
+	server := NewServer(nil, config.Pools)
+
+	for query, want := range map[string]string{
+		`{ logs(filter: {pool: "unknown"}) { items { hash } } }`:                                          "unknown pool",
+		`{ logs(first: 0) { items { hash } } }`:                                                           "first must be",
+		`{ logs(after: "x") { items { hash } } }`:                                                         "invalid cursor",
+		`{ users(wallet: "wallet") { pool } }`:                                                            "invalid wallet",
+		`{ logs(filter: {asset: "x"}) { items { hash } } }`:                                               "BigInt",
+		`{ syncStates(pool: "main") { pool unknownField } }`:                                              "unknownField",
+		`{ syncStates(pool: "unknown") { pool } }`:                                                        "unknown pool",
+		`{ users(wallet: "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa", pool: "unknown") { pool } }`: "unknown pool",
+	} {
+		body, _ := json.Marshal(map[string]string{"query": query})
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body)))
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s: want error %q, got %s", query, want, rec.Body)
+		}
+	}
+}
+
+func TestGraphQLWS(t *testing.T) {
+	httpServer := httptest.NewServer(NewServer(nil, config.Pools))
+	defer httpServer.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{graphqlWSProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/graphql", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	exchange := func(msg wsMessage, wantType string) wsMessage {
+		t.Helper()
+		if err := conn.WriteJSON(msg); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		var reply wsMessage
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if reply.Type != wantType {
+			t.Fatalf("want %s, got %s %s", wantType, reply.Type, reply.Payload)
+		}
+		return reply
+	}
+
+	exchange(wsMessage{Type: "connection_init"}, "connection_ack")
+	exchange(wsMessage{Type: "ping"}, "pong")
+
+	payload, _ := json.Marshal(wsSubscribePayload{Query: `subscription { logIndexed(filter: {pool: "unknown"}) { hash } }`})
+	reply := exchange(wsMessage{ID: "1", Type: "subscribe", Payload: payload}, "error")
+	if reply.ID != "1" || !strings.Contains(string(reply.Payload), "unknown pool") {
+		t.Errorf("want unknown pool error of operation 1, got %s %s", reply.ID, reply.Payload)
+	}
+
+	// an unknown client message closes the connection
+	if err := conn.WriteJSON(wsMessage{Type: "unknown"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	var closeErr *websocket.CloseError
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != closeInvalidMessage {
+		t.Errorf("want close %d, got %v", closeInvalidMessage, err)
+	}
+}
+
+func TestGraphQLUserLogs(t *testing.T) {
+	db := testDB(t)
+
+	pool := config.Pool{Name: "test_graphql", Address: config.PoolMain.Address}
+	wallet := "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa"
+	cleanup := func() {
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainLog{})
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainUser{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	user := config.OnchainUser{
+		WalletAddress:   wallet,
+		Pool:            pool.Name,
+		ContractAddress: "test_graphql_contract",
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+		State:           config.BigInt{Int: big.NewInt(0)},
+		Principals:      config.Principals{config.BigInt{Int: big.NewInt(11)}: config.BigInt{Int: big.NewInt(-500)}},
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("cannot create user: %v", err)
+	}
+	for i, txType := range []string{"supply", "withdraw"} {
+		idxLog := config.OnchainLog{Hash: "G1", Pool: pool.Name, Utime: 1700000000, Lt: 100, MsgIndex: i, TxType: txType,
+			SenderAddress: "test_graphql_contract", UserAddress: wallet}
+		if err := db.Create(&idxLog).Error; err != nil {
+			t.Fatalf("cannot create log: %v", err)
+		}
+	}
+
+	query := `{ users(wallet: "` + wallet + `", pool: "test_graphql") { pool principals { asset principal } logs(filter: {txType: "withdraw"}) { items { txType msgIndex } } } }`
+	body, _ := json.Marshal(map[string]string{"query": query})
+	rec := httptest.NewRecorder()
+	NewServer(db, []config.Pool{pool}).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body)))
+
+	want := `{"data":{"users":[{"pool":"test_graphql","principals":[{"asset":"11","principal":"-500"}],` +
+		`"logs":{"items":[{"txType":"withdraw","msgIndex":1}]}}]}}`
+	if strings.TrimSpace(rec.Body.String()) != want {
+		t.Errorf("want %s, got %s", want, rec.Body)
+	}
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// graphqlWSProtocol is the graphql-transport-ws protocol of the graphql-ws library,
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const graphqlWSProtocol = "graphql-transport-ws"
+
+// connectionInitTimeout is how long a client has to send connection_init after connecting
+const connectionInitTimeout = 10 * time.Second
+
+// close codes of the protocol
+const (
+	closeInitTimeout       = 4408
+	closeUnauthorized      = 4401
+	closeDuplicateID       = 4409
+	closeInvalidMessage    = 4400
+	closeTooManyInitialize = 4429
+)
+
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{graphqlWSProtocol},
+	// the API is read-only and public, any page may subscribe
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type wsSubscribePayload struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// wsConn serializes writes to the connection, every operation writes from its own goroutine.
+type wsConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+func (c *wsConn) send(id, messageType string, payload interface{}) error {
+	msg := wsMessage{ID: id, Type: messageType}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		msg.Payload = data
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(msg)
+}
+
+func (c *wsConn) close(code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	_ = c.conn.Close()
+}
+
+// serveGraphQLWS runs operations of a graphql-transport-ws connection until the client disconnects.
+func serveGraphQLWS(w http.ResponseWriter, r *http.Request, schema *graphql.Schema) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	c := &wsConn{conn: conn}
+	if conn.Subprotocol() != graphqlWSProtocol {
+		c.close(websocket.CloseProtocolError, "subprotocol "+graphqlWSProtocol+" is required")
+		return
+	}
+
+	// r.Context() is not canceled once the connection is hijacked: operations are canceled
+	// before waiting for them when the client disconnects
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	initialized := false
+	operations := make(map[string]context.CancelFunc)
+	var mu sync.Mutex
+
+	_ = conn.SetReadDeadline(time.Now().Add(connectionInitTimeout))
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if !initialized {
+				c.close(closeInitTimeout, "connection initialisation timeout")
+			}
+			return
+		}
+
+		switch msg.Type {
+		case "connection_init":
+			if initialized {
+				c.close(closeTooManyInitialize, "too many initialisation requests")
+				return
+			}
+			initialized = true
+			_ = conn.SetReadDeadline(time.Time{})
+			if err := c.send("", "connection_ack", nil); err != nil {
+				return
+			}
+
+		case "ping":
+			if err := c.send("", "pong", nil); err != nil {
+				return
+			}
+
+		case "pong":
+
+		case "subscribe":
+			if !initialized {
+				c.close(closeUnauthorized, "unauthorized")
+				return
+			}
+			var payload wsSubscribePayload
+			if msg.ID == "" || json.Unmarshal(msg.Payload, &payload) != nil {
+				c.close(closeInvalidMessage, "invalid subscribe message")
+				return
+			}
+
+			mu.Lock()
+			_, exists := operations[msg.ID]
+			opCtx, opCancel := context.WithCancel(ctx)
+			if !exists {
+				operations[msg.ID] = opCancel
+			}
+			mu.Unlock()
+			if exists {
+				opCancel()
+				c.close(closeDuplicateID, fmt.Sprintf("subscriber for %s already exists", msg.ID))
+				return
+			}
+
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				defer func() {
+					mu.Lock()
+					delete(operations, id)
+					mu.Unlock()
+					opCancel()
+				}()
+				runWSOperation(opCtx, c, schema, id, payload)
+			}(msg.ID)
+
+		case "complete":
+			mu.Lock()
+			if opCancel, ok := operations[msg.ID]; ok {
+				opCancel()
+			}
+			mu.Unlock()
+
+		default:
+			c.close(closeInvalidMessage, "invalid message type "+msg.Type)
+			return
+		}
+	}
+}
+
+// runWSOperation sends the results of the operation: errors of a query which cannot be run are sent
+// as error, otherwise results are sent as next followed by complete.
+func runWSOperation(ctx context.Context, c *wsConn, schema *graphql.Schema, id string, payload wsSubscribePayload) {
+	responses, err := schema.Subscribe(ctx, payload.Query, payload.OperationName, payload.Variables)
+	if err != nil {
+		_ = c.send(id, "error", []map[string]string{{"message": err.Error()}})
+		return
+	}
+
+	first := true
+	for response := range responses {
+		resp, ok := response.(*graphql.Response)
+		if !ok {
+			continue
+		}
+		if first && resp.Data == nil && len(resp.Errors) > 0 {
+			_ = c.send(id, "error", resp.Errors)
+			return
+		}
+		first = false
+		if err := c.send(id, "next", resp); err != nil {
+			return
+		}
+	}
+
+	if ctx.Err() == nil {
+		_ = c.send(id, "complete", nil)
+	}
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Log is a pool log, asset ids and amounts are decimal strings.
+type Log struct {
+	Hash                              string        `json:"hash"`
+	Pool                              string        `json:"pool"`
+	Lt                                int64         `json:"lt"`
+	MsgIndex                          int           `json:"msg_index"`
+	Utime                             int64         `json:"utime"`
+	TxType                            string        `json:"tx_type"`
+	TxSubType                         string        `json:"tx_sub_type"`
+	LogVersion                        int           `json:"log_version"`
+	SenderAddress                     string        `json:"sender_address"`
+	UserAddress                       string        `json:"user_address"`
+	SubaccountID                      int16         `json:"subaccount_id"`
+	AttachedAssetAddress              config.BigInt `json:"attached_asset_address"`
+	AttachedAssetAmount               config.BigInt `json:"attached_asset_amount"`
+	AttachedAssetPrincipal            config.BigInt `json:"attached_asset_principal"`
+	AttachedAssetTotalSupplyPrincipal config.BigInt `json:"attached_asset_total_supply_principal"`
+	AttachedAssetTotalBorrowPrincipal config.BigInt `json:"attached_asset_total_borrow_principal"`
+	AttachedAssetSRate                config.BigInt `json:"attached_asset_s_rate"`
+	AttachedAssetBRate                config.BigInt `json:"attached_asset_b_rate"`
+	RedeemedAssetAddress              config.BigInt `json:"redeemed_asset_address"`
+	RedeemedAssetAmount               config.BigInt `json:"redeemed_asset_amount"`
+	RedeemedAssetPrincipal            config.BigInt `json:"redeemed_asset_principal"`
+	RedeemedAssetTotalSupplyPrincipal config.BigInt `json:"redeemed_asset_total_supply_principal"`
+	RedeemedAssetTotalBorrowPrincipal config.BigInt `json:"redeemed_asset_total_borrow_principal"`
+	RedeemedAssetSRate                config.BigInt `json:"redeemed_asset_s_rate"`
+	RedeemedAssetBRate                config.BigInt `json:"redeemed_asset_b_rate"`
+	CreatedAt                         time.Time     `json:"created_at"`
+}
+
+func newLog(l config.OnchainLog) Log {
+	return Log{
+		Hash:                              l.Hash,
+		Pool:                              l.Pool,
+		Lt:                                l.Lt,
+		MsgIndex:                          l.MsgIndex,
+		Utime:                             l.Utime,
+		TxType:                            l.TxType,
+		TxSubType:                         l.TxSubType,
+		LogVersion:                        l.LogVersion,
+		SenderAddress:                     l.SenderAddress,
+		UserAddress:                       l.UserAddress,
+		SubaccountID:                      l.SubaccountID,
+		AttachedAssetAddress:              l.AttachedAssetAddress,
+		AttachedAssetAmount:               l.AttachedAssetAmount,
+		AttachedAssetPrincipal:            l.AttachedAssetPrincipal,
+		AttachedAssetTotalSupplyPrincipal: l.AttachedAssetTotalSupplyPrincipal,
+		AttachedAssetTotalBorrowPrincipal: l.AttachedAssetTotalBorrowPrincipal,
+		AttachedAssetSRate:                l.AttachedAssetSRate,
+		AttachedAssetBRate:                l.AttachedAssetBRate,
+		RedeemedAssetAddress:              l.RedeemedAssetAddress,
+		RedeemedAssetAmount:               l.RedeemedAssetAmount,
+		RedeemedAssetPrincipal:            l.RedeemedAssetPrincipal,
+		RedeemedAssetTotalSupplyPrincipal: l.RedeemedAssetTotalSupplyPrincipal,
+		RedeemedAssetTotalBorrowPrincipal: l.RedeemedAssetTotalBorrowPrincipal,
+		RedeemedAssetSRate:                l.RedeemedAssetSRate,
+		RedeemedAssetBRate:                l.RedeemedAssetBRate,
+		CreatedAt:                         l.CreatedAt,
+	}
+}
+
+// SyncState is the sync cursor of a pool.
+type SyncState struct {
+	Pool      string `json:"pool"`
+	LastLt    int64  `json:"last_lt"`
+	LastHash  string `json:"last_hash"`
+	LastUtime int64  `json:"last_utime"`
+}
+
+// Page is a page of results, the next one is requested with the cursor parameter set to NextCursor.
+// NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// logCursor is the last log of a page, logs are returned from the newest one
+type logCursor struct {
+	Utime    int64  `json:"utime"`
+	Lt       int64  `json:"lt"`
+	Pool     string `json:"pool"`
+	Hash     string `json:"hash"`
+	MsgIndex int    `json:"msg_index"`
+}
+
+func (c logCursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseLogCursor(s string) (*logCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c logCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+// logQuery selects logs by the common parameters of log endpoints:
+// cursor, limit, from and to (unix time, from inclusive, to exclusive), type and sub_type.
+type logQuery struct {
+	cursor    *logCursor
+	limit     int
+	from, to  int64
+	txType    string
+	txSubType string
+}
+
+func parseLogQuery(r *http.Request) (logQuery, error) {
+	params := r.URL.Query()
+	q := logQuery{
+		limit:     defaultLimit,
+		txType:    params.Get("type"),
+		txSubType: params.Get("sub_type"),
+	}
+
+	var err error
+	if s := params.Get("cursor"); s != "" {
+		if q.cursor, err = parseLogCursor(s); err != nil {
+			return q, err
+		}
+	}
+	if s := params.Get("limit"); s != "" {
+		if q.limit, err = strconv.Atoi(s); err != nil || q.limit < 1 || q.limit > maxLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+	}
+	if s := params.Get("from"); s != "" {
+		if q.from, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return q, errors.New("from must be a unix time")
+		}
+	}
+	if s := params.Get("to"); s != "" {
+		if q.to, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return q, errors.New("to must be a unix time")
+		}
+	}
+
+	return q, nil
+}
+
+// find returns a page of the logs selected by query, newest first.
+func (q logQuery) find(query *gorm.DB) (Page[Log], error) {
+	if q.from > 0 {
+		query = query.Where("utime >= ?", q.from)
+	}
+	if q.to > 0 {
+		query = query.Where("utime < ?", q.to)
+	}
+	if q.txType != "" {
+		query = query.Where("tx_type = ?", q.txType)
+	}
+	if q.txSubType != "" {
+		query = query.Where("tx_sub_type = ?", q.txSubType)
+	}
+	if c := q.cursor; c != nil {
+		query = query.Where("(utime, lt, pool, hash, msg_index) < (?, ?, ?, ?, ?)", c.Utime, c.Lt, c.Pool, c.Hash, c.MsgIndex)
+	}
+
+	var logs []config.OnchainLog
+	err := query.Order("utime DESC, lt DESC, pool DESC, hash DESC, msg_index DESC").Limit(q.limit + 1).Find(&logs).Error
+	if err != nil {
+		return Page[Log]{}, fmt.Errorf("error per reading logs: %w", err)
+	}
+
+	page := Page[Log]{Items: make([]Log, 0, len(logs))}
+	if len(logs) > q.limit {
+		logs = logs[:q.limit]
+		last := logs[len(logs)-1]
+		page.NextCursor = logCursor{Utime: last.Utime, Lt: last.Lt, Pool: last.Pool, Hash: last.Hash, MsgIndex: last.MsgIndex}.String()
+	}
+	for _, l := range logs {
+		page.Items = append(page.Items, newLog(l))
+	}
+	return page, nil
+}
+
+// getPoolLogs serves /pools/{pool}/logs?from=&to=&type=&sub_type=&cursor=&limit=
+func (s *Server) getPoolLogs(w http.ResponseWriter, r *http.Request) {
+	pool, ok := s.pool(r.PathValue("pool"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown pool %s", r.PathValue("pool")))
+		return
+	}
+	q, err := parseLogQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := q.find(s.db.Model(&config.OnchainLog{}).Where("pool = ?", pool.Name))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// getSyncState serves /pools/{pool}/sync-state
+func (s *Server) getSyncState(w http.ResponseWriter, r *http.Request) {
+	pool, ok := s.pool(r.PathValue("pool"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown pool %s", r.PathValue("pool")))
+		return
+	}
+
+	var state config.OnchainSyncState
+	err := s.db.Where("pool = ?", pool.Name).Take(&state).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("pool %s is not synced yet", pool.Name))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("error per reading sync state: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, SyncState{Pool: state.Pool, LastLt: state.LastLt, LastHash: state.LastHash, LastUtime: state.LastUtime})
+}
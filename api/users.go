@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/xssnick/tonutils-go/address"
+	"gorm.io/gorm"
+)
+
+// User is a user position in a pool, the state and principals are decimal strings.
+type User struct {
+	WalletAddress   string            `json:"wallet_address"`
+	Pool            string            `json:"pool"`
+	SubaccountID    int16             `json:"subaccount_id"`
+	ContractAddress string            `json:"contract_address"`
+	CodeVersion     int               `json:"code_version"`
+	State           config.BigInt     `json:"state"`
+	Principals      config.Principals `json:"principals"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+}
+
+func newUser(u config.OnchainUser) User {
+	return User{
+		WalletAddress:   u.WalletAddress,
+		Pool:            u.Pool,
+		SubaccountID:    u.SubaccountID,
+		ContractAddress: u.ContractAddress,
+		CodeVersion:     u.CodeVersion,
+		State:           u.State,
+		Principals:      u.Principals,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+	}
+}
+
+// parseWallet accepts a wallet address in any form and returns it in the form addresses are indexed in.
+func parseWallet(s string) (string, error) {
+	wallet, err := address.ParseAddr(s)
+	if err != nil {
+		if wallet, err = address.ParseRawAddr(s); err != nil {
+			return "", fmt.Errorf("invalid wallet address %s", s)
+		}
+	}
+	return address.NewAddress(0, byte(wallet.Workchain()), wallet.Data()).String(), nil
+}
+
+// userFilter returns a scope selecting rows of the wallet path value by the column, and of the pool
+// and subaccount parameters.
+func (s *Server) userFilter(r *http.Request, column string) (func(*gorm.DB) *gorm.DB, int, error) {
+	wallet, err := parseWallet(r.PathValue("wallet"))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	params := r.URL.Query()
+	var pool *config.Pool
+	if name := params.Get("pool"); name != "" {
+		p, ok := s.pool(name)
+		if !ok {
+			return nil, http.StatusNotFound, fmt.Errorf("unknown pool %s", name)
+		}
+		pool = &p
+	}
+	var subaccountID *int64
+	if sub := params.Get("subaccount"); sub != "" {
+		id, err := strconv.ParseInt(sub, 10, 16)
+		if err != nil {
+			return nil, http.StatusBadRequest, errors.New("invalid subaccount")
+		}
+		subaccountID = &id
+	}
+
+	return func(query *gorm.DB) *gorm.DB {
+		query = query.Where(column+" = ?", wallet)
+		if pool != nil {
+			query = query.Where("pool = ?", pool.Name)
+		}
+		if subaccountID != nil {
+			query = query.Where("subaccount_id = ?", *subaccountID)
+		}
+		return query
+	}, http.StatusOK, nil
+}
+
+// getUsers serves /users/{wallet}?pool=&subaccount=, all positions of the wallet
+func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
+	filter, status, err := s.userFilter(r, "wallet_address")
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	var users []config.OnchainUser
+	if err := s.db.Scopes(filter).Order("pool, subaccount_id").Find(&users).Error; err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("error per reading users: %w", err))
+		return
+	}
+	if len(users) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("user is not indexed"))
+		return
+	}
+
+	page := Page[User]{Items: make([]User, 0, len(users))}
+	for _, u := range users {
+		page.Items = append(page.Items, newUser(u))
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// getUserHistory serves /users/{wallet}/history?pool=&subaccount= with the log parameters, see logQuery
+func (s *Server) getUserHistory(w http.ResponseWriter, r *http.Request) {
+	filter, status, err := s.userFilter(r, "user_address")
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	q, err := parseLogQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := q.find(s.db.Model(&config.OnchainLog{}).Scopes(filter))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
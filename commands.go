@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/evaafi/go-indexer/api"
+	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/health"
+	"github.com/evaafi/go-indexer/indexer"
+	"github.com/evaafi/go-indexer/liquidator"
+	"github.com/evaafi/go-indexer/metrics"
+	"github.com/evaafi/go-indexer/migrations"
+	"gorm.io/gorm"
+)
+
+// runIndexer indexes all pools and refreshes users until SIGINT or SIGTERM:
+//
+//	run
+func runIndexer(db *gorm.DB, cfg config.Config, args []string) error {
+	if err := flag.NewFlagSet("run", flag.ContinueOnError).Parse(args); err != nil {
+		return err
+	}
+
+	if cfg.ForceResyncOnEveryStart {
+		slog.Info("force resync enabled, truncating all indexing tables")
+		for _, table := range tables {
+			if err := db.Exec(fmt.Sprintf("TRUNCATE TABLE %s RESTART IDENTITY CASCADE;", config.GetTableName(db, table))).Error; err != nil {
+				return fmt.Errorf("error per truncating table %s: %w", config.GetTableName(db, table), err)
+			}
+		}
+		slog.Info("all indexing tables truncated")
+	}
+
+	config.EnsureInitialIdxSyncStateData(db)
+
+	if err := setChainSource(cfg); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slog.Info("starting indexing")
+	go indexer.RunIndexer(ctx, cfg)
+
+	if cfg.APIAddress != "" {
+		slog.Info("starting api", "address", cfg.APIAddress)
+		server := api.NewServer(db, config.Pools)
+		server.Handle("GET /metrics", metrics.Handler())
+		server.Handle("GET /healthz", health.Handler(health.DB(db)))
+		server.Handle("GET /readyz", health.Handler(indexer.ReadinessChecks(db, cfg, config.Pools)...))
+		go func() {
+			if err := server.ListenAndServe(ctx, cfg.APIAddress); err != nil {
+				slog.Error("api server stopped", "address", cfg.APIAddress, "err", err)
+			}
+		}()
+	}
+
+	if cfg.Mode == config.ModeLiquidator {
+		slog.Info("starting liquidator")
+		go liquidator.Run(ctx, cfg)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	<-sigs
+	slog.Info("received termination signal, stopping application")
+
+	close(indexer.Shutdown)
+
+	indexer.WG.Wait()
+	cancel()
+
+	time.Sleep(3 * time.Second)
+
+	return nil
+}
+
+// runMigrate applies, reverts or lists the versioned schema migrations:
+//
+//	migrate [up] [-dry-run]
+//	migrate down [-steps n] [-dry-run]
+//	migrate partition-logs [-dry-run]
+//	migrate status
+func runMigrate(db *gorm.DB, _ config.Config, args []string) error {
+	action := "up"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+
+	flags := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "print the SQL instead of running it")
+	steps := flags.Int("steps", 1, "number of migrations to revert")
+	acceptDowntime := flags.Bool("accept-downtime", false, "allow partition-logs to block log writes while onchain_logs is converted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	opts := migrations.Options{DryRun: *dryRun, Out: os.Stdout}
+
+	switch action {
+	case "up":
+		applied, err := migrations.Up(db, opts)
+		if *dryRun {
+			fmt.Printf("-- %d migrations would be applied\n", len(applied))
+			return err
+		}
+		for _, m := range applied {
+			fmt.Printf("applied %s\n", m)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migrations applied\n", len(applied))
+	case "down":
+		reverted, err := migrations.Down(db, *steps, opts)
+		if *dryRun {
+			fmt.Printf("-- %d migrations would be reverted\n", len(reverted))
+			return err
+		}
+		for _, m := range reverted {
+			fmt.Printf("reverted %s\n", m)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migrations reverted\n", len(reverted))
+	case "partition-logs":
+		if !*dryRun && !*acceptDowntime {
+			return fmt.Errorf("partition-logs blocks log writes until onchain_logs is copied and indexed, run it with -accept-downtime")
+		}
+		if err := migrations.PartitionLogs(db, opts); err != nil {
+			return err
+		}
+		if !*dryRun {
+			fmt.Println("onchain_logs is partitioned by month")
+		}
+	case "status":
+		applied, err := migrations.Applied(db)
+		if err != nil {
+			return err
+		}
+		for _, a := range applied {
+			fmt.Printf("applied %04d_%s at %s\n", a.Version, a.Name, a.AppliedAt.UTC().Format(time.RFC3339))
+		}
+
+		pending, err := migrations.Pending(db)
+		if err != nil {
+			return err
+		}
+		for _, m := range pending {
+			fmt.Printf("pending %s\n", m)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q", action)
+	}
+
+	return nil
+}
+
+// runBackfill indexes transactions of pools in a range without moving their sync cursors, pools are
+// backfilled concurrently. The status command prints the saved progress of every pool:
+//
+//	backfill -pool name[,name]|all [-from utime] [-to utime] [-from-lt lt] [-to-lt lt] [-restart]
+//	backfill status
+func runBackfill(db *gorm.DB, cfg config.Config, args []string) error {
+	if len(args) > 0 && args[0] == "status" {
+		return printBackfillProgress(db)
+	}
+
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	poolNames := flags.String("pool", "", "comma separated pools to backfill, all for every pool")
+	var rng indexer.BackfillRange
+	flags.Int64Var(&rng.FromUtime, "from", 0, "first unix time to index")
+	flags.Int64Var(&rng.ToUtime, "to", 0, "unix time to stop at, exclusive")
+	flags.Int64Var(&rng.FromLt, "from-lt", 0, "first lt to index")
+	flags.Int64Var(&rng.ToLt, "to-lt", 0, "lt to stop at, exclusive")
+	restart := flags.Bool("restart", false, "start over instead of continuing an interrupted backfill of the range")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *poolNames == "" {
+		return fmt.Errorf("usage: backfill -pool name[,name]|all [-from utime] [-to utime] [-from-lt lt] [-to-lt lt] [-restart]")
+	}
+
+	pools := config.Pools
+	if *poolNames != "all" {
+		pools = nil
+		for _, name := range strings.Split(*poolNames, ",") {
+			pool, err := poolByName(strings.TrimSpace(name))
+			if err != nil {
+				return err
+			}
+			pools = append(pools, pool)
+		}
+	}
+	if err := setChainSource(cfg); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errs := make([]error, len(pools))
+	var wg sync.WaitGroup
+	for i, pool := range pools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			progress, err := indexer.Backfill(ctx, cfg, pool, rng, *restart)
+			if err != nil {
+				errs[i] = fmt.Errorf("backfill %s: %w", pool.Name, err)
+				return
+			}
+			fmt.Printf("backfill %s finished: %d transactions, %d logs\n", pool.Name, progress.Transactions, progress.Logs)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func printBackfillProgress(db *gorm.DB) error {
+	progress, err := indexer.BackfillProgress(db)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range progress {
+		status := "interrupted"
+		if p.FinishedAt != nil {
+			status = "finished at " + p.FinishedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Printf("pool=%s range=[utime %d..%d, lt %d..%d] transactions=%d logs=%d last_lt=%d last_utime=%d started_at=%s updated_at=%s %s\n",
+			p.Pool, p.FromUtime, p.ToUtime, p.FromLt, p.ToLt, p.Transactions, p.Logs, p.LastLt, p.LastUtime,
+			p.StartedAt.UTC().Format(time.RFC3339), p.UpdatedAt.UTC().Format(time.RFC3339), status)
+	}
+
+	return nil
+}
+
+// runRefreshUser reads the state of one user from the chain source and stores it right away:
+//
+//	refresh-user <wallet> -pool name [-subaccount id]
+func runRefreshUser(_ *gorm.DB, cfg config.Config, args []string) error {
+	var wallet string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		wallet, args = args[0], args[1:]
+	}
+
+	flags := flag.NewFlagSet("refresh-user", flag.ContinueOnError)
+	poolName := flags.String("pool", "", "pool of the user")
+	subaccount := flags.Int("subaccount", 0, "subaccount id of the user")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if wallet == "" {
+		wallet = flags.Arg(0)
+	}
+	if wallet == "" {
+		return fmt.Errorf("usage: refresh-user <wallet> -pool name [-subaccount id]")
+	}
+
+	pool, err := poolByName(*poolName)
+	if err != nil {
+		return err
+	}
+	if err := setChainSource(cfg); err != nil {
+		return err
+	}
+
+	user, err := indexer.RefreshUser(pool, wallet, int16(*subaccount))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("user %s subaccount %d of %s pool refreshed, contract %s state %s\n",
+		user.WalletAddress, user.SubaccountID, user.Pool, user.ContractAddress, user.State.String())
+
+	return nil
+}
+
+// runQueue moves the user update queue between databases or processes it right away:
+//
+//	queue dump [-file path]
+//	queue load [-file path]
+//	queue drain
+func runQueue(db *gorm.DB, cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: queue dump|load [-file path] | queue drain")
+	}
+
+	flags := flag.NewFlagSet("queue "+args[0], flag.ContinueOnError)
+	file := flags.String("file", "-", "JSON file with queue items, - for stdout or stdin")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "dump":
+		out := os.Stdout
+		if *file != "-" {
+			f, err := os.Create(*file)
+			if err != nil {
+				return fmt.Errorf("error writing to file: %w", err)
+			}
+			defer f.Close()
+			out = f
+		}
+
+		dumped, err := indexer.DumpQueue(db, out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%d queue items dumped\n", dumped)
+	case "load":
+		in := os.Stdin
+		if *file != "-" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return fmt.Errorf("error reading file: %w", err)
+			}
+			defer f.Close()
+			in = f
+		}
+
+		loaded, err := indexer.LoadQueue(db, in)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d queue items loaded\n", loaded)
+	case "drain":
+		if err := setChainSource(cfg); err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		updated, failed, err := indexer.DrainQueue(ctx, cfg)
+		fmt.Printf("%d users updated, %d failed and rescheduled\n", updated, failed)
+		return err
+	default:
+		return fmt.Errorf("unknown queue command %q", args[0])
+	}
+
+	return nil
+}
+
+// runState shows or moves the sync cursors of the pools:
+//
+//	state show
+//	state set-cursor -pool name [-lt lt -hash hash] [-utime utime]
+func runState(db *gorm.DB, _ config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: state show | state set-cursor -pool name [-lt lt -hash hash] [-utime utime]")
+	}
+
+	flags := flag.NewFlagSet("state "+args[0], flag.ContinueOnError)
+	poolName := flags.String("pool", "", "pool of the cursor")
+	lt := flags.Int64("lt", 0, "lt of the last processed transaction")
+	hash := flags.String("hash", "", "hash of the last processed transaction")
+	utime := flags.Int64("utime", 0, "unix time of the last processed transaction")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "show":
+		var states []config.OnchainSyncState
+		if err := db.Order("pool").Find(&states).Error; err != nil {
+			return fmt.Errorf("error per reading sync states: %w", err)
+		}
+		for _, s := range states {
+			fmt.Printf("pool=%s last_lt=%d last_hash=%s last_utime=%d (%s)\n",
+				s.Pool, s.LastLt, s.LastHash, s.LastUtime, time.Unix(s.LastUtime, 0).UTC().Format(time.RFC3339))
+		}
+	case "set-cursor":
+		pool, err := poolByName(*poolName)
+		if err != nil {
+			return err
+		}
+		if *lt <= 0 && *utime <= 0 || *lt > 0 && *hash == "" {
+			return fmt.Errorf("usage: state set-cursor -pool name [-lt lt -hash hash] [-utime utime]")
+		}
+
+		state := config.OnchainSyncState{Pool: pool.Name}
+		if err := db.Where("pool = ?", pool.Name).Take(&state).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("error per reading sync state: %w", err)
+		}
+		// a cursor without lt is followed by time, the running indexer switches it to lt itself
+		state.LastLt = *lt
+		state.LastHash = *hash
+		if *lt <= 0 {
+			state.LastHash = ""
+		}
+		if *utime > 0 {
+			state.LastUtime = *utime
+		}
+
+		if err := db.Save(&state).Error; err != nil {
+			return fmt.Errorf("error updating IdxSyncState: %w", err)
+		}
+		fmt.Printf("pool=%s last_lt=%d last_hash=%s last_utime=%d\n", state.Pool, state.LastLt, state.LastHash, state.LastUtime)
+	default:
+		return fmt.Errorf("unknown state command %q", args[0])
+	}
+
+	return nil
+}
+
+// runDeadLetters lists or replays user updates which ran out of attempts:
+//
+//	dead-letters list [-pool name] [-contract address]
+//	dead-letters replay [-pool name] [-contract address]
+func runDeadLetters(db *gorm.DB, _ config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: dead-letters list|replay [-pool name] [-contract address]")
+	}
+
+	flags := flag.NewFlagSet("dead-letters "+args[0], flag.ContinueOnError)
+	pool := flags.String("pool", "", "only dead letters of the pool")
+	contract := flags.String("contract", "", "only dead letters of the user contract")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		query := db.Order("id")
+		if *pool != "" {
+			query = query.Where("pool = ?", *pool)
+		}
+		if *contract != "" {
+			query = query.Where("contract_address = ?", *contract)
+		}
+
+		var deadLetters []config.OnchainUpdateDeadLetter
+		if err := query.Find(&deadLetters).Error; err != nil {
+			return fmt.Errorf("error per reading dead letters: %w", err)
+		}
+		for _, d := range deadLetters {
+			fmt.Printf("%d pool=%s contract=%s wallet=%s attempts=%d failed_at=%s error=%s\n",
+				d.ID, d.Pool, d.ContractAddress, d.UserAddress, d.Attempts, d.FailedAt.Format(time.RFC3339), d.LastError)
+		}
+		fmt.Printf("%d dead letters\n", len(deadLetters))
+	case "replay":
+		replayed, err := indexer.ReplayDeadLetters(db, *pool, *contract)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d users returned to the update queue\n", replayed)
+	default:
+		return fmt.Errorf("unknown dead-letters command %q", args[0])
+	}
+
+	return nil
+}
+
+// runReparse decodes stored log bodies again with the current decoders:
+//
+//	reparse [-pool name] [-opcode 0x16] [-from utime] [-to utime]
+func runReparse(db *gorm.DB, _ config.Config, args []string) error {
+	flags := flag.NewFlagSet("reparse", flag.ContinueOnError)
+	pool := flags.String("pool", "", "only logs of the pool")
+	opCode := flags.String("opcode", "", "only logs with the opcode, e.g. 0x16")
+	from := flags.Int64("from", 0, "only logs at or after the unix time")
+	to := flags.Int64("to", 0, "only logs before the unix time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := indexer.ReparseFilter{Pool: *pool, FromUtime: *from, ToUtime: *to}
+	if *opCode != "" {
+		value, err := strconv.ParseUint(*opCode, 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid opcode %q: %w", *opCode, err)
+		}
+		filter.OpCode = &value
+	}
+
+	result, err := indexer.Reparse(db, filter)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d logs reparsed, %d unparsed logs recovered, %d logs failed\n", result.Reparsed, result.Recovered, result.Failed)
+
+	return nil
+}
+
+func poolByName(name string) (config.Pool, error) {
+	for _, pool := range config.Pools {
+		if pool.Name == name {
+			return pool, nil
+		}
+	}
+
+	names := make([]string, 0, len(config.Pools))
+	for _, pool := range config.Pools {
+		names = append(names, pool.Name)
+	}
+	return config.Pool{}, fmt.Errorf("unknown pool %q, one of %s is expected", name, strings.Join(names, ", "))
+}
+
+// setChainSource creates the configured chain source for commands reading the blockchain
+func setChainSource(cfg config.Config) error {
+	source, err := indexer.NewChainSource(cfg)
+	if err != nil {
+		return fmt.Errorf("error per creating chain source: %w", err)
+	}
+	indexer.SetChainSource(source)
+
+	return nil
+}
@@ -17,9 +17,64 @@ const (
 
 type DBType string
 
+type DataSource string
+
+const (
+	DataSourceDton       DataSource = "dton"
+	DataSourceToncenter  DataSource = "toncenter"
+	DataSourceLiteserver DataSource = "liteserver"
+)
+
+type TracingExporter string
+
+const (
+	TracingExporterNone TracingExporter = "none"
+	TracingExporterOTLP TracingExporter = "otlp"
+)
+
+// Pool is an EVAA master contract the indexer follows. Pools are listed in the pools section of
+// config.yaml, DefaultPools are used when it is empty.
 type Pool struct {
-	Name    string
-	Address string
+	Name    string `yaml:"name"`
+	Address string `yaml:"address"`
+	// StartUtime and StartLt are the initial sync cursor of the pool, transactions after StartUtime
+	// are indexed first, or transactions after StartLt when it is set
+	StartUtime int64 `yaml:"startUtime"`
+	StartLt    int64 `yaml:"startLt"`
+	// LogVersions are the lts the pool started to emit logs of another layout at, ordered by lt.
+	// Logs are decoded with the version of the last boundary at their lt, the first boundary must
+	// cover the transactions the pool is indexed from. Without a version 2 boundary, version 1 logs
+	// with a subaccount id are decoded as version 2
+	LogVersions []LogVersionBoundary `yaml:"logVersions"`
+	// SDKConfig names the SDK config of the pool assets and user contracts, e.g. main-mainnet
+	SDKConfig string `yaml:"sdkConfig"`
+}
+
+// LogVersionBoundary is the lt logs of the version are emitted from.
+type LogVersionBoundary struct {
+	Version int   `yaml:"version"`
+	FromLt  int64 `yaml:"fromLt"`
+}
+
+// LogVersionAt returns the version of the last boundary at or before the lt, ok is false when there is none.
+func (p Pool) LogVersionAt(lt int64) (version int, ok bool) {
+	for _, boundary := range p.LogVersions {
+		if boundary.FromLt > lt {
+			break
+		}
+		version, ok = boundary.Version, true
+	}
+	return version, ok
+}
+
+// HasLogVersion reports whether the pool has a boundary of the version.
+func (p Pool) HasLogVersion(version int) bool {
+	for _, boundary := range p.LogVersions {
+		if boundary.Version == version {
+			return true
+		}
+	}
+	return false
 }
 
 /*func mustParseBigInt(s string) *big.Int {
@@ -31,29 +86,53 @@ type Pool struct {
 }*/
 
 var (
-	UtimeAddendum int64 = 60 * 60 * 24 * 31
-	PoolMain            = Pool{
-		Name:    "main",
-		Address: "EQC8rUZqR_pWV1BylWUlPNBzyiTYVoBEmQkMIQDZXICfnuRr",
+	PoolMain = Pool{
+		Name:       "main",
+		Address:    "EQC8rUZqR_pWV1BylWUlPNBzyiTYVoBEmQkMIQDZXICfnuRr",
+		StartUtime: 1714879105,
+		LogVersions: []LogVersionBoundary{
+			{Version: 0, FromLt: 0},
+			{Version: 1, FromLt: 49828980000001},
+		},
+		SDKConfig: "main-mainnet",
 	}
 	PoolLp = Pool{
-		Name:    "lp",
-		Address: "EQBIlZX2URWkXCSg3QF2MJZU-wC5XkBoLww-hdWk2G37Jc6N",
+		Name:       "lp",
+		Address:    "EQBIlZX2URWkXCSg3QF2MJZU-wC5XkBoLww-hdWk2G37Jc6N",
+		StartUtime: 1725205342,
+		LogVersions: []LogVersionBoundary{
+			{Version: 0, FromLt: 0},
+			{Version: 1, FromLt: 49712577000001},
+		},
+		SDKConfig: "lp-mainnet",
 	}
 	PoolAlts = Pool{
-		Name:    "alts",
-		Address: "EQANURVS3fhBO9bivig34iyJQi97FhMbpivo1aUEAS2GYSu-",
+		Name:       "alts",
+		Address:    "EQANURVS3fhBO9bivig34iyJQi97FhMbpivo1aUEAS2GYSu-",
+		StartUtime: 1732117342,
+		LogVersions: []LogVersionBoundary{
+			{Version: 1, FromLt: 0},
+		},
+		SDKConfig: "alts-mainnet",
 	}
 	PoolStable = Pool{
-		Name:    "stable",
-		Address: "EQCdIdXf1kA_2Hd9mbGzSFDEPA-Px-et8qTWHEXgRGo0K3zd",
+		Name:       "stable",
+		Address:    "EQCdIdXf1kA_2Hd9mbGzSFDEPA-Px-et8qTWHEXgRGo0K3zd",
+		StartUtime: 1751328000,
+		LogVersions: []LogVersionBoundary{
+			{Version: 1, FromLt: 0},
+		},
+		SDKConfig: "stable-mainnet",
 	}
-	Pools = []Pool{
+	// DefaultPools are the EVAA mainnet pools
+	DefaultPools = []Pool{
 		PoolMain,
 		PoolLp,
 		PoolAlts,
 		PoolStable,
 	}
+	// Pools are the pools the process works with, set from the loaded config
+	Pools = DefaultPools
 	/*AssetMapping = map[string]*big.Int{
 		"ton":             mustParseBigInt("11876925370864614464799087627157805050745321306404563164673853337929163193738"),
 		"usdt":            mustParseBigInt("91621667903763073563570557639433445791506232618002614896981036659302854767224"),
@@ -88,21 +167,46 @@ const (
 )
 
 type Config struct {
-	Mode                    Mode    `yaml:"mode"`
-	DBType                  DBType  `yaml:"dbType"`
-	DBHost                  string  `yaml:"dbHost"`
-	DBPort                  int16   `yaml:"dbPort"`
-	DBUser                  string  `yaml:"dbUser"`
-	DBPass                  string  `yaml:"dbPass"`
-	DBName                  string  `yaml:"dbName"`
-	GraphQLEndpoint         string  `yaml:"graphqlEndpoint"`
-	UserSyncWorkers         int     `yaml:"userSyncWorkers"`
-	ForceResyncOnEveryStart bool    `yaml:"forceResyncOnEveryStart"`
-	MigrateOnStart          bool    `yaml:"migrateOnStart"`
-	MaxPageSize             int     `yaml:"maxPageSize"`
-	TonCenterAPIKey         string  `yaml:"toncenterApiKey"`
-	TonCenterRPS            float64 `yaml:"toncenterRPS"`
-	TonCenterBurst          int     `yaml:"toncenterBurst"`
+	Mode                    Mode              `yaml:"mode"`
+	DBType                  DBType            `yaml:"dbType"`
+	DBHost                  string            `yaml:"dbHost"`
+	DBPort                  int16             `yaml:"dbPort"`
+	DBUser                  string            `yaml:"dbUser"`
+	DBPass                  string            `yaml:"dbPass"`
+	DBName                  string            `yaml:"dbName"`
+	DataSource              DataSource        `yaml:"dataSource"`
+	DataSources             []DataSource      `yaml:"dataSources"`
+	StateQuorum             bool              `yaml:"stateQuorum"`
+	GraphQLEndpoint         string            `yaml:"graphqlEndpoint"`
+	UserSyncWorkers         int               `yaml:"userSyncWorkers"`
+	ForceResyncOnEveryStart bool              `yaml:"forceResyncOnEveryStart"`
+	MigrateOnStart          bool              `yaml:"migrateOnStart"`
+	MaxPageSize             int               `yaml:"maxPageSize"`
+	TonCenterEndpoint       string            `yaml:"toncenterEndpoint"`
+	TonCenterAPIKey         string            `yaml:"toncenterApiKey"`
+	TonCenterRPS            float64           `yaml:"toncenterRPS"`
+	TonCenterBurst          int               `yaml:"toncenterBurst"`
+	LiteserverConfigPath    string            `yaml:"liteserverConfigPath"`
+	LiteserverProofCheck    string            `yaml:"liteserverProofCheck"`
+	PriceEndpoints          []string          `yaml:"priceEndpoints"`
+	LiquidatorInterval      int               `yaml:"liquidatorInterval"`
+	UpdateMaxAttempts       int               `yaml:"updateMaxAttempts"`
+	UpdateRetryDelay        int               `yaml:"updateRetryDelay"`
+	UpdateRetryMaxDelay     int               `yaml:"updateRetryMaxDelay"`
+	APIAddress              string            `yaml:"apiAddress"`
+	ReadyMaxCursorLag       int               `yaml:"readyMaxCursorLag"`
+	ReadyMaxDueUpdates      int               `yaml:"readyMaxDueUpdates"`
+	ReadyMaxSourceFailure   int               `yaml:"readyMaxSourceFailure"`
+	LogLevel                string            `yaml:"logLevel"`
+	LogFormat               LogFormat         `yaml:"logFormat"`
+	LogSampleEvery          int               `yaml:"logSampleEvery"`
+	TracingExporter         TracingExporter   `yaml:"tracingExporter"`
+	TracingServiceName      string            `yaml:"tracingServiceName"`
+	TracingSampleRatio      float64           `yaml:"tracingSampleRatio"`
+	OTLPEndpoint            string            `yaml:"otlpEndpoint"`
+	OTLPInsecure            bool              `yaml:"otlpInsecure"`
+	OTLPHeaders             map[string]string `yaml:"otlpHeaders"`
+	Pools                   []Pool            `yaml:"pools"`
 }
 
 func LoadConfig(path string) (Config, error) {
@@ -111,6 +215,12 @@ func LoadConfig(path string) (Config, error) {
 	if err != nil {
 		return cfg, err
 	}
-	err = yaml.Unmarshal(data, &cfg)
-	return cfg, err
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+
+	if len(cfg.Pools) == 0 {
+		cfg.Pools = DefaultPools
+	}
+	return cfg, nil
 }
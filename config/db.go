@@ -5,9 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/big"
-	"os"
 	"sync"
 	"time"
 
@@ -36,11 +35,11 @@ func GetDBInstance() (*gorm.DB, error) {
 
 		DBInstance, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
 			Logger: logger.New(
-				log.New(os.Stdout, "\r\n", log.LstdFlags),
+				// database warnings and errors go to the default logger
+				slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
 				logger.Config{
 					SlowThreshold: 0,
 					LogLevel:      logger.Warn,
-					Colorful:      true,
 				},
 			),
 		})
@@ -151,9 +150,14 @@ func (b *BigInt) Scan(value interface{}) error {
 type OnchainLog struct {
 	Hash                              string    `gorm:"primaryKey;column:hash;type:string"`
 	Pool                              string    `gorm:"primaryKey;column:pool"`
-	Utime                             int64     `gorm:"column:utime;not null"`
+	Utime                             int64     `gorm:"primaryKey;column:utime;not null"`
 	TxType                            string    `gorm:"column:tx_type;not null"`
 	TxSubType                         string    `gorm:"column:tx_sub_type;"`
+	LogVersion                        int       `gorm:"column:log_version;not null;default:0"`
+	Lt                                int64     `gorm:"column:lt;not null;default:0"`
+	MsgIndex                          int       `gorm:"primaryKey;column:msg_index;not null;default:0"`
+	Body                              *string   `gorm:"column:body"`
+	Legacy                            bool      `gorm:"column:legacy;not null;default:false"`
 	SenderAddress                     string    `gorm:"column:sender_address;not null"`
 	UserAddress                       string    `gorm:"column:user_address;not null"`
 	SubaccountID                      int16     `gorm:"column:subaccount_id;not null;default:0"`
@@ -177,18 +181,118 @@ type OnchainLog struct {
 type OnchainSyncState struct {
 	Pool      string `gorm:"primaryKey;column:pool"`
 	LastLt    int64  `gorm:"column:last_lt"`
+	LastHash  string `gorm:"column:last_hash"`
 	LastUtime int64  `gorm:"column:last_utime"`
 }
 
+// OnchainLiquidationCandidate is a liquidatable user position published by the liquidator,
+// ranked from the least healthy one.
+type OnchainLiquidationCandidate struct {
+	WalletAddress     string    `gorm:"primaryKey;column:wallet_address"`
+	Pool              string    `gorm:"primaryKey;column:pool"`
+	SubaccountID      int16     `gorm:"primaryKey;column:subaccount_id;default:0"`
+	ContractAddress   string    `gorm:"column:contract_address;not null"`
+	Rank              int       `gorm:"column:rank;not null"`
+	HealthFactor      float64   `gorm:"column:health_factor;not null"`
+	TotalSupply       BigInt    `gorm:"column:total_supply;type:NUMERIC"`
+	TotalDebt         BigInt    `gorm:"column:total_debt;type:NUMERIC"`
+	TotalLimit        BigInt    `gorm:"column:total_limit;type:NUMERIC"`
+	LoanAsset         BigInt    `gorm:"column:loan_asset;type:NUMERIC"`
+	LoanValue         BigInt    `gorm:"column:loan_value;type:NUMERIC"`
+	CollateralAsset   BigInt    `gorm:"column:collateral_asset;type:NUMERIC"`
+	CollateralValue   BigInt    `gorm:"column:collateral_value;type:NUMERIC"`
+	LiquidationAmount BigInt    `gorm:"column:liquidation_amount;type:NUMERIC"`
+	CollateralAmount  BigInt    `gorm:"column:collateral_amount;type:NUMERIC"`
+	BadDebt           bool      `gorm:"column:bad_debt;not null"`
+	UserUpdatedAt     time.Time `gorm:"column:user_updated_at;not null"`
+	UpdatedAt         time.Time `gorm:"column:updated_at;not null"`
+}
+
+// OnchainStateDiscrepancy is a user contract state that differed between two data sources in quorum mode.
+type OnchainStateDiscrepancy struct {
+	ID              uint      `gorm:"primaryKey;autoIncrement;column:id"`
+	ContractAddress string    `gorm:"column:contract_address;not null;index"`
+	FirstSource     string    `gorm:"column:first_source;not null"`
+	FirstHash       string    `gorm:"column:first_hash;not null"`
+	FirstData       string    `gorm:"column:first_data;not null"`
+	SecondSource    string    `gorm:"column:second_source;not null"`
+	SecondHash      string    `gorm:"column:second_hash;not null"`
+	SecondData      string    `gorm:"column:second_data;not null"`
+	CreatedAt       time.Time `gorm:"column:created_at;not null"`
+}
+
+// OnchainUpdateQueueItem is a user contract waiting for its state to be refreshed.
+// Workers claim items with next_run_at in the past, a claimed item is leased by moving its
+// next_run_at forward, so it is claimed again if the worker dies.
+type OnchainUpdateQueueItem struct {
+	ID              uint      `gorm:"primaryKey;autoIncrement;column:id"`
+	Pool            string    `gorm:"column:pool;not null;uniqueIndex:idx_update_queue_contract"`
+	ContractAddress string    `gorm:"column:contract_address;not null;uniqueIndex:idx_update_queue_contract"`
+	UserAddress     string    `gorm:"column:user_address;not null"`
+	SubaccountID    int16     `gorm:"column:subaccount_id;not null;default:0"`
+	TxUtime         int64     `gorm:"column:tx_utime;not null"`
+	Attempts        int       `gorm:"column:attempts;not null;default:0"`
+	NextRunAt       time.Time `gorm:"column:next_run_at;not null;index"`
+	LastError       string    `gorm:"column:last_error"`
+	Version         int64     `gorm:"column:version;not null;default:0"`
+	TraceParent     string    `gorm:"column:trace_parent;not null;default:''"`
+	CreatedAt       time.Time `gorm:"column:created_at;not null"`
+	// DueAt is the next_run_at the item was claimed at, before it was leased
+	DueAt time.Time `gorm:"-" json:"-"`
+}
+
+// OnchainUpdateDeadLetter is a queue item which failed updateMaxAttempts times in a row,
+// it is not retried until replayed.
+type OnchainUpdateDeadLetter struct {
+	ID              uint      `gorm:"primaryKey;autoIncrement;column:id"`
+	Pool            string    `gorm:"column:pool;not null;index"`
+	ContractAddress string    `gorm:"column:contract_address;not null"`
+	UserAddress     string    `gorm:"column:user_address;not null"`
+	SubaccountID    int16     `gorm:"column:subaccount_id;not null;default:0"`
+	TxUtime         int64     `gorm:"column:tx_utime;not null"`
+	Attempts        int       `gorm:"column:attempts;not null"`
+	LastError       string    `gorm:"column:last_error;not null"`
+	CreatedAt       time.Time `gorm:"column:created_at;not null"`
+	FailedAt        time.Time `gorm:"column:failed_at;not null"`
+}
+
+// OnchainUnparsedLog is a pool log which could not be decoded, it is kept to be reparsed
+// once a decoder for it is added.
+type OnchainUnparsedLog struct {
+	Hash      string    `gorm:"primaryKey;column:hash"`
+	Pool      string    `gorm:"primaryKey;column:pool"`
+	MsgIndex  int       `gorm:"primaryKey;column:msg_index"`
+	Lt        int64     `gorm:"column:lt;not null"`
+	Utime     int64     `gorm:"column:utime;not null"`
+	OpCode    *int64    `gorm:"column:op_code;index"`
+	Body      string    `gorm:"column:body;not null"`
+	Error     string    `gorm:"column:error;not null"`
+	CreatedAt time.Time `gorm:"column:created_at;not null"`
+}
+
+// OnchainBackfillState is the progress of a pool backfill. A stopped backfill of the same range
+// continues from its cursor, zero range bounds are open.
+type OnchainBackfillState struct {
+	Pool         string     `gorm:"primaryKey;column:pool"`
+	FromUtime    int64      `gorm:"column:from_utime;not null"`
+	ToUtime      int64      `gorm:"column:to_utime;not null"`
+	FromLt       int64      `gorm:"column:from_lt;not null"`
+	ToLt         int64      `gorm:"column:to_lt;not null"`
+	LastLt       int64      `gorm:"column:last_lt;not null"`
+	LastHash     string     `gorm:"column:last_hash;not null"`
+	LastUtime    int64      `gorm:"column:last_utime;not null"`
+	Transactions int64      `gorm:"column:transactions;not null"`
+	Logs         int64      `gorm:"column:logs;not null"`
+	StartedAt    time.Time  `gorm:"column:started_at;not null"`
+	UpdatedAt    time.Time  `gorm:"column:updated_at;not null"`
+	FinishedAt   *time.Time `gorm:"column:finished_at"`
+}
+
+// EnsureInitialIdxSyncStateData creates the sync state of every pool without one at the start cursor of the pool.
 func EnsureInitialIdxSyncStateData(db *gorm.DB) {
-	initialData := []OnchainSyncState{
-		{Pool: "main", LastLt: 0, LastUtime: 1714879105},
-		{Pool: "alts", LastLt: 0, LastUtime: 1732117342},
-		{Pool: "lp", LastLt: 0, LastUtime: 1725205342},
-		{Pool: "stable", LastLt: 0, LastUtime: 1751328000},
-	}
+	for _, pool := range Pools {
+		data := OnchainSyncState{Pool: pool.Name, LastLt: pool.StartLt, LastUtime: pool.StartUtime}
 
-	for _, data := range initialData {
 		var existing OnchainSyncState
 
 		err := db.First(&existing, "pool = ?", data.Pool).Error
@@ -196,12 +300,12 @@ func EnsureInitialIdxSyncStateData(db *gorm.DB) {
 		if err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 				if err := db.Create(&data).Error; err != nil {
-					fmt.Printf("Failed to insert initial data for pool %s: %v\n", data.Pool, err)
+					slog.Error("failed to insert initial sync state", "pool", data.Pool, "err", err)
 				} else {
-					fmt.Printf("Inserted initial data for pool %s\n", data.Pool)
+					slog.Info("inserted initial sync state", "pool", data.Pool, "last_utime", data.LastUtime)
 				}
 			} else {
-				fmt.Printf("Error checking existing record: %v\n", err)
+				slog.Error("error checking existing sync state", "pool", data.Pool, "err", err)
 			}
 		}
 	}
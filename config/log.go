@@ -0,0 +1,84 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+type LogFormat string
+
+const (
+	LogFormatText LogFormat = "text"
+	LogFormatJSON LogFormat = "json"
+)
+
+// defaultLogSampleEvery is how many high-volume messages are logged once by default
+const defaultLogSampleEvery = 100
+
+var logSampleEvery atomic.Int64
+
+func init() {
+	logSampleEvery.Store(defaultLogSampleEvery)
+}
+
+// NewLogger returns a logger writing to w at the logLevel (debug, info, warn or error, info by default)
+// in the logFormat (text by default).
+func NewLogger(cfg Config, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if cfg.LogLevel != "" {
+		if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+			return nil, fmt.Errorf("invalid logLevel %q", cfg.LogLevel)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch LogFormat(strings.ToLower(string(cfg.LogFormat))) {
+	case LogFormatText, "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown logFormat %q", cfg.LogFormat)
+	}
+}
+
+// SetupLogging makes the logger configured in cfg the default one and sets how often sampled messages are logged.
+func SetupLogging(cfg Config, w io.Writer) error {
+	logger, err := NewLogger(cfg, w)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	every := int64(defaultLogSampleEvery)
+	if cfg.LogSampleEvery > 0 {
+		every = int64(cfg.LogSampleEvery)
+	}
+	logSampleEvery.Store(every)
+
+	return nil
+}
+
+// Sampler logs the first and then one of every logSampleEvery messages, the logged ones carry the
+// sample rate. All messages are logged at the debug level or when logSampleEvery is 1.
+type Sampler struct {
+	count atomic.Int64
+}
+
+// Info logs the message at the info level if it is sampled.
+func (s *Sampler) Info(msg string, args ...any) {
+	n := s.count.Add(1)
+	every := logSampleEvery.Load()
+
+	if every > 1 && !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		if (n-1)%every != 0 {
+			return
+		}
+		args = append(args, slog.Int64("sample_every", every))
+	}
+	slog.Info(msg, args...)
+}
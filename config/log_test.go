@@ -0,0 +1,68 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(Config{LogLevel: "warn", LogFormat: "JSON"}, &buf)
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+
+	logger.Info("hidden")
+	logger.Warn("cannot parse log message", "pool", "main", "lt", int64(42))
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("want one JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "cannot parse log message" || record["pool"] != "main" || record["lt"] != float64(42) {
+		t.Errorf("unexpected record %v", record)
+	}
+
+	for _, cfg := range []Config{{LogLevel: "verbose"}, {LogFormat: "xml"}} {
+		if _, err := NewLogger(cfg, &buf); err == nil {
+			t.Errorf("want error for %+v", cfg)
+		}
+	}
+}
+
+func TestSampler(t *testing.T) {
+	previous := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		logSampleEvery.Store(defaultLogSampleEvery)
+	})
+
+	var buf bytes.Buffer
+	if err := SetupLogging(Config{LogSampleEvery: 3}, &buf); err != nil {
+		t.Fatalf("SetupLogging: %v", err)
+	}
+
+	var sampler Sampler
+	for i := 0; i < 7; i++ {
+		sampler.Info("user updated", "pool", "main")
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "sample_every=3") {
+		t.Errorf("want the 1st, 4th and 7th messages with the sample rate, got %q", lines)
+	}
+
+	// nothing is dropped at the debug level
+	buf.Reset()
+	if err := SetupLogging(Config{LogLevel: "debug", LogSampleEvery: 3}, &buf); err != nil {
+		t.Fatalf("SetupLogging: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		sampler.Info("user updated", "pool", "main")
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 4 || strings.Contains(lines[0], "sample_every") {
+		t.Errorf("want every message, got %q", lines)
+	}
+}
@@ -0,0 +1,165 @@
+// Package events is the in-process bus the indexer publishes what it writes to: indexed logs,
+// updated users and advanced sync cursors.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/evaafi/go-indexer/config"
+)
+
+// Kind is the type of an event.
+type Kind string
+
+const (
+	KindLogIndexed     Kind = "log_indexed"
+	KindUserUpdated    Kind = "user_updated"
+	KindCursorAdvanced Kind = "cursor_advanced"
+)
+
+// Event is one of LogIndexed, UserUpdated and CursorAdvanced.
+type Event interface {
+	Kind() Kind
+}
+
+// LogIndexed is published for every log of a pool page after the page is committed.
+type LogIndexed struct {
+	Log config.OnchainLog
+}
+
+// UserUpdated is published after the state of a user is stored.
+type UserUpdated struct {
+	User config.OnchainUser
+}
+
+// CursorAdvanced is published after the sync cursor of a pool is moved to the last transaction of a page.
+type CursorAdvanced struct {
+	State config.OnchainSyncState
+}
+
+func (LogIndexed) Kind() Kind     { return KindLogIndexed }
+func (UserUpdated) Kind() Kind    { return KindUserUpdated }
+func (CursorAdvanced) Kind() Kind { return KindCursorAdvanced }
+
+// Filter selects events, empty fields match everything. TxType matches logs only,
+// Wallet matches logs and users.
+type Filter struct {
+	Kinds  []Kind
+	Pool   string
+	Wallet string
+	TxType string
+}
+
+// Match reports whether the event is selected by the filter.
+func (f Filter) Match(e Event) bool {
+	if len(f.Kinds) > 0 {
+		found := false
+		for _, kind := range f.Kinds {
+			found = found || kind == e.Kind()
+		}
+		if !found {
+			return false
+		}
+	}
+
+	switch e := e.(type) {
+	case LogIndexed:
+		return match(f.Pool, e.Log.Pool) && match(f.Wallet, e.Log.UserAddress) && match(f.TxType, e.Log.TxType)
+	case UserUpdated:
+		return match(f.Pool, e.User.Pool) && match(f.Wallet, e.User.WalletAddress) && f.TxType == ""
+	case CursorAdvanced:
+		return match(f.Pool, e.State.Pool) && f.Wallet == "" && f.TxType == ""
+	}
+	return false
+}
+
+func match(want, value string) bool {
+	return want == "" || want == value
+}
+
+// Subscription receives events selected by its filter on C until it is closed.
+// Events are dropped when the subscriber does not keep up and its buffer is full.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	filter  Filter
+	bus     *Bus
+	dropped atomic.Int64
+}
+
+// Dropped returns the number of events dropped because the buffer was full.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close stops the subscription and closes C.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if _, ok := s.bus.subscriptions[s]; ok {
+		delete(s.bus.subscriptions, s)
+		close(s.c)
+	}
+}
+
+// Bus delivers published events to subscriptions, publishing never blocks.
+type Bus struct {
+	mu            sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subscriptions: make(map[*Subscription]struct{})}
+}
+
+// Subscribe returns a subscription to events selected by the filter, buffering up to buffer events.
+func (b *Bus) Subscribe(filter Filter, buffer int) *Subscription {
+	c := make(chan Event, buffer)
+	s := &Subscription{C: c, c: c, filter: filter, bus: b}
+
+	b.mu.Lock()
+	b.subscriptions[s] = struct{}{}
+	b.mu.Unlock()
+
+	return s
+}
+
+// Subscriptions returns the number of open subscriptions.
+func (b *Bus) Subscriptions() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscriptions)
+}
+
+// Publish sends the event to every subscription selecting it.
+func (b *Bus) Publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subscriptions {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Default is the bus of the indexer process.
+var Default = NewBus()
+
+// Publish sends the event to subscriptions of the default bus.
+func Publish(e Event) {
+	Default.Publish(e)
+}
+
+// Subscribe subscribes to the default bus.
+func Subscribe(filter Filter, buffer int) *Subscription {
+	return Default.Subscribe(filter, buffer)
+}
@@ -0,0 +1,60 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/evaafi/go-indexer/config"
+)
+
+func TestFilterMatch(t *testing.T) {
+	supply := LogIndexed{Log: config.OnchainLog{Pool: "main", UserAddress: "w1", TxType: "supply"}}
+	user := UserUpdated{User: config.OnchainUser{Pool: "main", WalletAddress: "w1"}}
+	cursor := CursorAdvanced{State: config.OnchainSyncState{Pool: "lp"}}
+
+	for _, tc := range []struct {
+		filter Filter
+		event  Event
+		want   bool
+	}{
+		{Filter{}, supply, true},
+		{Filter{}, cursor, true},
+		{Filter{Pool: "main", Wallet: "w1", TxType: "supply"}, supply, true},
+		{Filter{TxType: "withdraw"}, supply, false},
+		{Filter{Wallet: "w2"}, user, false},
+		{Filter{Wallet: "w1"}, user, true},
+		{Filter{TxType: "supply"}, user, false},
+		{Filter{Pool: "lp"}, cursor, true},
+		{Filter{Wallet: "w1"}, cursor, false},
+		{Filter{Kinds: []Kind{KindUserUpdated, KindCursorAdvanced}}, supply, false},
+		{Filter{Kinds: []Kind{KindUserUpdated, KindCursorAdvanced}}, cursor, true},
+	} {
+		if got := tc.filter.Match(tc.event); got != tc.want {
+			t.Errorf("%+v %+v: want %v, got %v", tc.filter, tc.event, tc.want, got)
+		}
+	}
+}
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	main := bus.Subscribe(Filter{Pool: "main"}, 1)
+	all := bus.Subscribe(Filter{}, 2)
+
+	bus.Publish(CursorAdvanced{State: config.OnchainSyncState{Pool: "main", LastLt: 1}})
+	bus.Publish(CursorAdvanced{State: config.OnchainSyncState{Pool: "main", LastLt: 2}})
+	bus.Publish(CursorAdvanced{State: config.OnchainSyncState{Pool: "lp", LastLt: 3}})
+
+	// the full buffer drops events instead of blocking the publisher
+	if e := (<-main.C).(CursorAdvanced); e.State.LastLt != 1 || main.Dropped() != 1 {
+		t.Errorf("want the first event and one dropped, got %d and %d dropped", e.State.LastLt, main.Dropped())
+	}
+	if len(all.C) != 2 || all.Dropped() != 1 {
+		t.Errorf("want 2 buffered events and one dropped, got %d and %d", len(all.C), all.Dropped())
+	}
+
+	main.Close()
+	main.Close()
+	bus.Publish(CursorAdvanced{State: config.OnchainSyncState{Pool: "main"}})
+	if _, ok := <-main.C; ok {
+		t.Error("want a closed subscription")
+	}
+}
@@ -0,0 +1,91 @@
+// Package health runs liveness and readiness checks and serves their results as JSON.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// checkTimeout is how long all checks of a request may take
+const checkTimeout = 5 * time.Second
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is a named condition, Run returns an error explaining why it is not met.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the outcome of all checks, it fails when any check fails.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Run runs the checks in order.
+func Run(ctx context.Context, checks []Check) Report {
+	report := Report{Status: StatusOK, Checks: make([]Result, 0, len(checks))}
+	for _, check := range checks {
+		result := Result{Name: check.Name, Status: StatusOK}
+		if err := check.Run(ctx); err != nil {
+			result.Status = StatusFail
+			result.Error = err.Error()
+			report.Status = StatusFail
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	return report
+}
+
+// Handler serves the report of the checks, with 503 Service Unavailable when any check fails.
+func Handler(checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		report := Run(ctx, checks)
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			slog.Error("error per writing health report", "status", report.Status, "err", err)
+		}
+	})
+}
+
+// DB checks that the database is reachable.
+func DB(db *gorm.DB) Check {
+	return Check{Name: "db", Run: func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		if err := sqlDB.PingContext(ctx); err != nil {
+			return fmt.Errorf("database is unreachable: %w", err)
+		}
+		return nil
+	}}
+}
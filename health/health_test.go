@@ -0,0 +1,56 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	ok := Check{Name: "ok", Run: func(context.Context) error { return nil }}
+	broken := Check{Name: "broken", Run: func(context.Context) error { return errors.New("pool main lags 1h0m0s") }}
+
+	tests := []struct {
+		checks []Check
+		status int
+		want   Report
+	}{
+		{
+			checks: []Check{ok},
+			status: http.StatusOK,
+			want:   Report{Status: StatusOK, Checks: []Result{{Name: "ok", Status: StatusOK}}},
+		},
+		{
+			checks: []Check{ok, broken},
+			status: http.StatusServiceUnavailable,
+			want: Report{Status: StatusFail, Checks: []Result{
+				{Name: "ok", Status: StatusOK},
+				{Name: "broken", Status: StatusFail, Error: "pool main lags 1h0m0s"},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		Handler(tt.checks...).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+		if rec.Code != tt.status {
+			t.Errorf("status = %d, want %d", rec.Code, tt.status)
+		}
+		var got Report
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if got.Status != tt.want.Status || len(got.Checks) != len(tt.want.Checks) {
+			t.Fatalf("report = %+v, want %+v", got, tt.want)
+		}
+		for i := range got.Checks {
+			if got.Checks[i] != tt.want.Checks[i] {
+				t.Errorf("check %d = %+v, want %+v", i, got.Checks[i], tt.want.Checks[i])
+			}
+		}
+	}
+}
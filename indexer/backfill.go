@@ -0,0 +1,153 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+	"gorm.io/gorm"
+)
+
+// BackfillRange selects transactions to backfill. From bounds are inclusive, to bounds are exclusive,
+// zero bounds are open: an empty range backfills the whole pool history.
+type BackfillRange struct {
+	FromUtime int64
+	ToUtime   int64
+	FromLt    int64
+	ToLt      int64
+}
+
+// start returns the cursor just before the first transaction of the range.
+func (r BackfillRange) start() TxCursor {
+	if r.FromLt > 0 {
+		// an empty hash goes before any transaction with the same lt
+		return TxCursor{Lt: r.FromLt}
+	}
+	return TxCursor{Utime: max(r.FromUtime-1, 0)}
+}
+
+// beforeEnd reports whether the transaction goes before the end of the range.
+func (r BackfillRange) beforeEnd(tx ProcessedTransaction) bool {
+	if r.ToUtime > 0 && tx.Utime >= r.ToUtime {
+		return false
+	}
+	return r.ToLt == 0 || tx.LT < r.ToLt
+}
+
+// afterStart drops transactions before FromUtime, which are returned when the range starts by lt.
+func (r BackfillRange) afterStart(transactions []ProcessedTransaction) []ProcessedTransaction {
+	var inRange []ProcessedTransaction
+	for _, tx := range transactions {
+		if tx.Utime >= r.FromUtime {
+			inRange = append(inRange, tx)
+		}
+	}
+	return inRange
+}
+
+func (r BackfillRange) matches(progress config.OnchainBackfillState) bool {
+	return progress.FromUtime == r.FromUtime && progress.ToUtime == r.ToUtime &&
+		progress.FromLt == r.FromLt && progress.ToLt == r.ToLt
+}
+
+// Backfill indexes pool transactions of the range into the existing tables. Logs already indexed are
+// replaced, so running it again gives the same result, users of the logs are enqueued for update.
+// The sync cursor of the pool is not moved, so a backfill can run next to the live indexer.
+//
+// Progress is saved in onchain_backfill_states with every page. An interrupted backfill of the same
+// range continues from its last page, unless restart is set.
+func Backfill(ctx context.Context, cfg config.Config, pool config.Pool, rng BackfillRange, restart bool) (*config.OnchainBackfillState, error) {
+	db, err := config.GetDBInstance()
+	if err != nil {
+		return nil, err
+	}
+
+	progress, err := loadBackfillProgress(db, pool, rng, restart)
+	if err != nil {
+		return nil, err
+	}
+	cursor := rng.start()
+	if progress.LastLt > 0 {
+		cursor = TxCursor{Lt: progress.LastLt, Hash: progress.LastHash, Utime: progress.LastUtime}
+	}
+
+	for ctx.Err() == nil {
+		transactions, err := chainSource.PoolTransactions(ctx, pool.Address, cursor, cfg.MaxPageSize)
+		if err != nil {
+			return progress, fmt.Errorf("error per processing transactions %s %d:%s: %w", pool.Name, cursor.Lt, cursor.Hash, err)
+		}
+
+		page := transactions
+		for i, tr := range transactions {
+			if !rng.beforeEnd(tr) {
+				page = transactions[:i]
+				break
+			}
+		}
+		finished := len(page) < len(transactions) || len(transactions) < cfg.MaxPageSize
+
+		indexed := parsePage(ctx, pool, rng.afterStart(page))
+		if len(page) > 0 {
+			last := page[len(page)-1]
+			progress.LastLt = last.LT
+			progress.LastHash = last.Hash
+			progress.LastUtime = last.Utime
+		}
+		progress.Transactions += int64(len(page))
+		progress.Logs += int64(len(indexed.logs))
+		progress.UpdatedAt = time.Now()
+		if finished {
+			finishedAt := progress.UpdatedAt
+			progress.FinishedAt = &finishedAt
+		}
+
+		if err := indexed.commit(ctx, db, progress, true); err != nil {
+			return progress, err
+		}
+
+		slog.Info("backfill progress", "pool", pool.Name, "transactions", progress.Transactions, "logs", progress.Logs,
+			"lt", progress.LastLt, "hash", progress.LastHash, "utime", time.Unix(progress.LastUtime, 0).UTC())
+
+		if finished {
+			return progress, nil
+		}
+		cursor = TxCursor{Lt: progress.LastLt, Hash: progress.LastHash, Utime: progress.LastUtime}
+	}
+
+	return progress, ctx.Err()
+}
+
+// loadBackfillProgress returns the saved progress of an interrupted backfill of the range, otherwise
+// a new one: after another range, a finished backfill or with restart set.
+func loadBackfillProgress(db *gorm.DB, pool config.Pool, rng BackfillRange, restart bool) (*config.OnchainBackfillState, error) {
+	var progress config.OnchainBackfillState
+	err := db.Where("pool = ?", pool.Name).Take(&progress).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("error per reading backfill progress: %w", err)
+	}
+	if err == nil && !restart && progress.FinishedAt == nil && rng.matches(progress) {
+		return &progress, nil
+	}
+
+	return &config.OnchainBackfillState{
+		Pool:      pool.Name,
+		FromUtime: rng.FromUtime,
+		ToUtime:   rng.ToUtime,
+		FromLt:    rng.FromLt,
+		ToLt:      rng.ToLt,
+		StartedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}, nil
+}
+
+// BackfillProgress returns saved backfill progress of all pools.
+func BackfillProgress(db *gorm.DB) ([]config.OnchainBackfillState, error) {
+	var progress []config.OnchainBackfillState
+	if err := db.Order("pool").Find(&progress).Error; err != nil {
+		return nil, fmt.Errorf("error per reading backfill progress: %w", err)
+	}
+	return progress, nil
+}
@@ -0,0 +1,150 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/xssnick/tonutils-go/address"
+)
+
+func TestBackfill(t *testing.T) {
+	db := testDB(t)
+
+	pool := testPool(t, "test_backfill")
+	user := address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
+
+	var transactions []ProcessedTransaction
+	for i := 0; i < 3; i++ {
+		utime := 1700000000 + i
+		transactions = append(transactions, ProcessedTransaction{
+			Hash:         fmt.Sprintf("B%d", i+1),
+			LT:           int64(100 + i),
+			Utime:        int64(utime),
+			OutMsgBodies: []string{supplyLogBody(user, uint32(utime))},
+		})
+	}
+	SetChainSource(&fakeSource{transactions: transactions})
+
+	cleanup := func() {
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainLog{})
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainUpdateQueueItem{})
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainSyncState{})
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainBackfillState{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	state := config.OnchainSyncState{Pool: pool.Name, LastLt: 500, LastHash: "live", LastUtime: 1710000000}
+	if err := db.Create(&state).Error; err != nil {
+		t.Fatalf("cannot create sync state: %v", err)
+	}
+
+	cfg := config.Config{MaxPageSize: 10}
+	rng := BackfillRange{FromUtime: 1700000000, ToUtime: 1700000002}
+	progress, err := Backfill(context.Background(), cfg, pool, rng, false)
+	if err != nil {
+		t.Fatalf("Backfill: %v", err)
+	}
+	if progress.Transactions != 2 || progress.Logs != 2 || progress.FinishedAt == nil || progress.LastHash != "B2" {
+		t.Errorf("want 2 transactions before the end of the range, got %+v", progress)
+	}
+
+	// a finished range is backfilled again, replacing the same logs
+	if _, err := Backfill(context.Background(), cfg, pool, rng, false); err != nil {
+		t.Fatalf("Backfill again: %v", err)
+	}
+
+	var logsCount int64
+	db.Model(&config.OnchainLog{}).Where("pool = ?", pool.Name).Count(&logsCount)
+	if logsCount != 2 {
+		t.Errorf("want 2 logs, got %d", logsCount)
+	}
+
+	var after config.OnchainSyncState
+	db.Where("pool = ?", pool.Name).First(&after)
+	if after != state {
+		t.Errorf("backfill moved the live cursor: %+v", after)
+	}
+
+	saved, err := BackfillProgress(db.Where("pool = ?", pool.Name))
+	if err != nil || len(saved) != 1 || saved[0].Transactions != 2 {
+		t.Errorf("want saved progress of 2 transactions, got %+v %v", saved, err)
+	}
+}
+
+func TestBackfillRange(t *testing.T) {
+	rng := BackfillRange{FromUtime: 1700000000, FromLt: 100, ToLt: 200}
+
+	if start := rng.start(); start.Lt != 100 || !start.Precedes(ProcessedTransaction{LT: 100, Hash: "A"}) {
+		t.Errorf("range starting by lt must include the first lt, got cursor %+v", start)
+	}
+	if !rng.beforeEnd(ProcessedTransaction{LT: 199}) || rng.beforeEnd(ProcessedTransaction{LT: 200}) {
+		t.Error("to lt must be exclusive")
+	}
+
+	inRange := rng.afterStart([]ProcessedTransaction{{Hash: "early", Utime: 1699999999}, {Hash: "in", Utime: 1700000000}})
+	if len(inRange) != 1 || inRange[0].Hash != "in" {
+		t.Errorf("want transactions before from utime dropped, got %+v", inRange)
+	}
+
+	byTime := BackfillRange{FromUtime: 1700000000, ToUtime: 1700000010}
+	if start := byTime.start(); start.Lt != 0 || !start.Precedes(ProcessedTransaction{Utime: 1700000000}) {
+		t.Errorf("range starting by time must include the first second, got cursor %+v", start)
+	}
+	if byTime.beforeEnd(ProcessedTransaction{Utime: 1700000010}) {
+		t.Error("to utime must be exclusive")
+	}
+}
+
+func TestBackfillReplacesLegacyLog(t *testing.T) {
+	db := testDB(t)
+
+	pool := testPool(t, "test_backfill_legacy")
+	user := address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
+
+	// the log is the second out message of its transaction, it was stored with index 0 before 0004
+	tr := ProcessedTransaction{
+		Hash:          "L1",
+		LT:            100,
+		Utime:         1700000000,
+		OutMsgBodies:  []string{supplyLogBody(user, 1700000000)},
+		OutMsgIndexes: []int{1},
+	}
+	SetChainSource(&fakeSource{transactions: []ProcessedTransaction{tr}})
+
+	cleanup := func() {
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainLog{})
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainUpdateQueueItem{})
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainBackfillState{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	legacy := config.OnchainLog{
+		Hash:        tr.Hash,
+		Pool:        pool.Name,
+		Utime:       tr.Utime,
+		TxType:      MessageTypeSupply,
+		UserAddress: user.String(),
+		Legacy:      true,
+	}
+	if err := db.Create(&legacy).Error; err != nil {
+		t.Fatalf("cannot create legacy log: %v", err)
+	}
+
+	rng := BackfillRange{FromUtime: 1700000000, ToUtime: 1700000001}
+	if _, err := Backfill(context.Background(), config.Config{MaxPageSize: 10}, pool, rng, false); err != nil {
+		t.Fatalf("Backfill: %v", err)
+	}
+
+	var logs []config.OnchainLog
+	db.Where("pool = ?", pool.Name).Find(&logs)
+	if len(logs) != 1 {
+		t.Fatalf("want 1 log, got %d", len(logs))
+	}
+	if logs[0].Legacy || logs[0].MsgIndex != 1 || logs[0].Lt != 100 || logs[0].Body == nil {
+		t.Errorf("want the legacy log replaced, got %+v", logs[0])
+	}
+}
@@ -2,15 +2,19 @@ package indexer
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
-	//"os"
 	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/tracing"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Transaction struct {
@@ -88,38 +92,79 @@ type GraphQLStatesResponse struct {
 type ProcessedTransaction struct {
 	Hash         string   `json:"hash"`
 	LT           int64    `json:"lt"`
+	Utime        int64    `json:"utime"`
 	OutMsgBodies []string `json:"out_msg_body"`
+	// OutMsgIndexes are indexes of OutMsgBodies messages among all out messages of the transaction
+	OutMsgIndexes []int `json:"out_msg_index"`
 }
 
-// out_msg_op_code
-func GetRawTransactions(url, address string, page_size, page int, utimeStart, utimeEnd int64) (string, error) {
-	/*query := fmt.Sprintf(`
-		{
-		raw_transactions(
-			order_by: "lt",
-			address_friendly: "%s",
-			out_msg_type__has: "ext_out_msg_info",
-			page_size: %d
-			page: %d
-			lt_gte: "%s"
-		) {
-			lt
-			hash
-			out_msg_type
-			out_msg_body
-			out_msg_dest_addr_address_hex
-		}
-	}`, address, page_size, page, lt)*/
+// outMsgIndex returns the out message index of the i-th body.
+func (tx ProcessedTransaction) outMsgIndex(i int) int {
+	if i < len(tx.OutMsgIndexes) {
+		return tx.OutMsgIndexes[i]
+	}
+	return i
+}
+
+// DtonSource is a ChainSource backed by the dton GraphQL API.
+type DtonSource struct {
+	endpoint string
+}
+
+func NewDtonSource(endpoint string) *DtonSource {
+	return &DtonSource{endpoint: endpoint}
+}
+
+func (s *DtonSource) PoolTransactions(ctx context.Context, poolAddress string, after TxCursor, limit int) ([]ProcessedTransaction, error) {
+	ctx, span := tracing.Start(ctx, "ProcessTransactions", trace.WithAttributes(tracing.Contract(poolAddress), tracing.Lt(after.Lt), tracing.TxHash(after.Hash)))
+	transactions, err := ProcessTransactions(ctx, s.endpoint, poolAddress, after, limit)
+	tracing.End(span, err)
+
+	return transactions, err
+}
+
+func (s *DtonSource) AccountState(ctx context.Context, address string) (*cell.Cell, error) {
+	ctx, span := tracing.Start(ctx, "GetRawState", trace.WithAttributes(tracing.Contract(address)))
+	rawState, err := GetRawState(ctx, s.endpoint, address)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	var stateResponse GraphQLStatesResponse
+	if err := json.Unmarshal([]byte(rawState), &stateResponse); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state %s: %w", rawState, err)
+	}
+
+	if len(stateResponse.Data.RawAccountStates) == 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrAccountStateNotFound, address, rawState)
+	}
+
+	dataBoc, err := base64.StdEncoding.DecodeString(stateResponse.Data.RawAccountStates[0].State)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 state %s: %w", stateResponse.Data.RawAccountStates[0].State, err)
+	}
+
+	return cell.FromBOC(dataBoc)
+}
+
+// GetRawTransactions requests a page of pool transactions with lt >= cursor lt ordered by lt,
+// transactions with gen_utime > cursor utime are requested for a cursor without lt.
+func GetRawTransactions(ctx context.Context, url, address string, page_size int, cursor TxCursor) (string, error) {
+	filter := fmt.Sprintf(`lt__gte: "%d"`, cursor.Lt)
+	if cursor.Lt == 0 {
+		filter = fmt.Sprintf(`gen_utime__gt: "%d"`, cursor.Utime)
+	}
+
 	query := fmt.Sprintf(`
 	{
 	raw_transactions(
-		order_by: "gen_utime",
+		order_by: "lt",
 		address_friendly: "%s",
 		out_msg_type__has: "ext_out_msg_info",
 		page_size: %d
-		page: %d
-		gen_utime__gt: "%d",
-		gen_utime__lte: "%d",
+		page: 0
+		%s
 	) {
 		lt
 		gen_utime__utc_unix
@@ -128,65 +173,12 @@ func GetRawTransactions(url, address string, page_size, page int, utimeStart, ut
 		out_msg_body
 		out_msg_dest_addr_address_hex
 	}
-}`, address, page_size, page, utimeStart, utimeEnd)
-	/*query := fmt.Sprintf(`
-	  {
-	  raw_transactions(
-	  	order_by: "gen_utime",
-	  	address_friendly: "%s",
-	  	out_msg_type__has: "ext_out_msg_info",
-	  	page_size: %d
-	  	page: %d
-	  	gen_utime__gt: "%d"
-	  	) {
-	  	lt
-	  	gen_utime__utc_unix
-	  	hash
-	  	out_msg_type
-	  	out_msg_body
-	  	out_msg_dest_addr_address_hex
-	  }
-	  }`, address, page_size, page, utimeStart)*/
-
-	/*file, err := os.OpenFile("queries.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	defer file.Close()
-	_, err = file.WriteString(query)
-
-	fmt.Println("File written successfully!")*/
-	payload := map[string]string{
-		"query": query,
-	}
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("error in payload: %v", err)
-		return "", err
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		log.Printf("error in req: %v", err)
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/json")
+}`, address, page_size, filter)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("error in sending http requset: %v", err)
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("error in receiving body: %v", err)
-		return "", err
-	}
-
-	return string(body), nil
+	return postQuery(ctx, url, query)
 }
 
-func GetRawState(url, userContractAddress string) (string, error) {
+func GetRawState(ctx context.Context, url, userContractAddress string) (string, error) {
 	query := fmt.Sprintf(`
 	{
 		raw_account_states(
@@ -209,40 +201,38 @@ func GetRawState(url, userContractAddress string) (string, error) {
 		}
 	}`, userContractAddress)*/
 
-	payload := map[string]string{
-		"query": query,
-	}
-	payloadBytes, err := json.Marshal(payload)
+	return postQuery(ctx, url, query)
+}
+
+// postQuery sends the GraphQL query to the dton endpoint and returns the response body.
+func postQuery(ctx context.Context, url, query string) (string, error) {
+	payloadBytes, err := json.Marshal(map[string]string{"query": query})
 	if err != nil {
-		log.Printf("error in payload: %v", err)
-		return "", err
+		return "", fmt.Errorf("error per encoding dton query: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payloadBytes))
 	if err != nil {
-		log.Printf("error in req: %v", err)
-		return "", err
+		return "", fmt.Errorf("error per creating dton request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("error in sending http requset: %v", err)
-		return "", err
+		return "", fmt.Errorf("error per requesting dton: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("error in receiving body: %v", err)
-		return "", err
+		return "", fmt.Errorf("error per reading dton response: %w", err)
 	}
 
 	return string(body), nil
 }
 
-func GetAccountState(url, userContractAddress string) (GraphQLStatesResponse, error) {
+func GetAccountState(ctx context.Context, url, userContractAddress string) (GraphQLStatesResponse, error) {
 	errors := 0
 
 	for {
@@ -250,15 +240,20 @@ func GetAccountState(url, userContractAddress string) (GraphQLStatesResponse, er
 			return GraphQLStatesResponse{}, fmt.Errorf("GetAccountState errors counter > 5")
 		}
 
-		responseStr, err := GetRawState(url, userContractAddress)
+		responseStr, err := GetRawState(ctx, url, userContractAddress)
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return GraphQLStatesResponse{}, ctx.Err()
+			}
+			slog.Warn("error per getting account state", "source", config.DataSourceDton, "address", userContractAddress, "err", err)
 			errors++
 			continue
 		}
 
 		var gqlResp GraphQLStatesResponse
 		if err := json.Unmarshal([]byte(responseStr), &gqlResp); err != nil {
+			slog.Warn("error per decoding account state", "source", config.DataSourceDton, "address", userContractAddress, "err", err)
 			errors++
 			continue
 		}
@@ -267,59 +262,54 @@ func GetAccountState(url, userContractAddress string) (GraphQLStatesResponse, er
 	}
 }
 
-func ProcessTransactions(url, address string, initialUtime int64, pageSize int) ([]ProcessedTransaction, int64, error) {
-	var results []ProcessedTransaction
-	currentUtime := initialUtime
-	page := 0
+// ProcessTransactions returns up to pageSize pool transactions going after the cursor.
+func ProcessTransactions(ctx context.Context, url, address string, cursor TxCursor, pageSize int) ([]ProcessedTransaction, error) {
 	errors := 0
 
 	for {
 		if errors == 5 {
-			return nil, currentUtime, fmt.Errorf("ProcessTransactions errors counter > 5")
+			return nil, fmt.Errorf("ProcessTransactions errors counter > 5")
 		}
 
-		responseStr, err := GetRawTransactions(url, address, pageSize, page, initialUtime, initialUtime+config.UtimeAddendum)
+		// the cursor transaction itself is returned by lt__gte
+		responseStr, err := GetRawTransactions(ctx, url, address, pageSize+1, cursor)
 		if err != nil {
-			fmt.Println(err)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			slog.Warn("error per getting transactions", "source", config.DataSourceDton, "address", address, "lt", cursor.Lt, "hash", cursor.Hash, "err", err)
 			errors++
 			continue
 		}
 
 		var gqlResp GraphQLTransactionsResponse
 		if err := json.Unmarshal([]byte(responseStr), &gqlResp); err != nil {
-			fmt.Println(err)
+			slog.Warn("error per decoding transactions", "source", config.DataSourceDton, "address", address, "lt", cursor.Lt, "hash", cursor.Hash, "err", err)
 			errors++
 			continue
 		}
 
-		page++
-		errors = 0
-		transactions := gqlResp.Data.RawTransactions
-		if len(transactions) == 0 {
-			// fmt.Println(responseStr);
-			break
-		}
-
-		for _, tx := range transactions {
+		var results []ProcessedTransaction
+		for _, tx := range gqlResp.Data.RawTransactions {
 			var bodies []string
+			var indexes []int
 			for idx, msgType := range tx.OutMsgType {
 				if msgType == "ext_out_msg_info" {
 					if idx < len(tx.OutMsgBody) {
 						bodies = append(bodies, tx.OutMsgBody[idx])
-						// fmt.Println("found body")
+						indexes = append(indexes, idx)
 					}
 				}
 			}
-			processed := ProcessedTransaction{
-				Hash:         tx.Hash,
-				LT:           tx.LT,
-				OutMsgBodies: bodies,
-			}
-
-			currentUtime = max(currentUtime, tx.Utime)
-			results = append(results, processed)
+			results = append(results, ProcessedTransaction{
+				Hash:          tx.Hash,
+				LT:            tx.LT,
+				Utime:         tx.Utime,
+				OutMsgBodies:  bodies,
+				OutMsgIndexes: indexes,
+			})
 		}
-	}
 
-	return results, currentUtime, nil
+		return pageAfter(results, cursor, pageSize), nil
+	}
 }
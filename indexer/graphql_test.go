@@ -1,34 +1,154 @@
 package indexer_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/evaafi/go-indexer/config"
 	"github.com/evaafi/go-indexer/indexer"
+	"github.com/xssnick/tonutils-go/tvm/cell"
 )
 
-func getData(cfg config.Config, st string) {
-	rawState, _ := indexer.GetRawState(cfg.GraphQLEndpoint, st)
+func getData(t *testing.T, endpoint, st string) []indexer.State {
+	t.Helper()
+
+	rawState, err := indexer.GetRawState(context.Background(), endpoint, st)
+	if err != nil {
+		t.Fatalf("GetRawState %s: %v", st, err)
+	}
 	var userStateResponse indexer.GraphQLStatesResponse
 	if err := json.Unmarshal([]byte(rawState), &userStateResponse); err != nil {
-		println("failed to unmarshal user state %s", rawState)
-		return
+		t.Fatalf("failed to unmarshal user state %s: %v", rawState, err)
 	}
+	return userStateResponse.Data.RawAccountStates
+}
 
-	println(userStateResponse.Data.RawAccountStates[0].State)
+func TestGetRawState(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("cannot decode request: %v", err)
+		}
+		if strings.Contains(payload["query"], "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa") {
+			fmt.Fprint(w, `{"data":{"raw_account_states":[{"account_state_state_init_data":"te6c"}]}}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"raw_account_states":[]}}`)
+	}))
+	defer server.Close()
 
-	if len(userStateResponse.Data.RawAccountStates) == 0 {
-		println("cannot get user state: %s; adding again to queue", st)
-		return
+	states := getData(t, server.URL, "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
+	if len(states) != 1 || states[0].State != "te6c" {
+		t.Errorf("want the user state, got %+v", states)
 	}
 
+	// an unknown contract has no state
+	if states := getData(t, server.URL, "EQD1_i5tUQ-0SrKKRZf588f1CY8E9GDt20eNsH_01acgBiWE"); len(states) != 0 {
+		t.Errorf("want no state, got %+v", states)
+	}
 }
-func TestGetRawState(t *testing.T) {
-	cfg, _ := config.LoadConfig("../config.yaml")
 
-	getData(cfg, "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
-	getData(cfg, "EQD1_i5tUQ-0SrKKRZf588f1CY8E9GDt20eNsH_01acgBiWE")
-	getData(cfg, "EQBR2DQ0olmo0QEFeELUWcnGnAJXeqTV4ZKqM3MFnpsJRg51")
-	getData(cfg, "EQCyno3tNXQIoJSWLURor_FNGZRilcVqUjeLOLMZF63PMzsg")
+func TestDtonSource(t *testing.T) {
+	stateCell := cell.BeginCell().MustStoreUInt(7, 8).EndCell()
+	stateBoc := base64.StdEncoding.EncodeToString(stateCell.ToBOC())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatalf("cannot decode request: %v", err)
+		}
+		query := payload["query"]
+
+		switch {
+		case strings.Contains(query, "raw_account_states") && strings.Contains(query, "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa"):
+			fmt.Fprintf(w, `{"data":{"raw_account_states":[{"account_state_state_init_data":"%s"}]}}`, stateBoc)
+		case strings.Contains(query, "raw_account_states"):
+			fmt.Fprint(w, `{"data":{"raw_account_states":[]}}`)
+		case strings.Contains(query, `gen_utime__gt: "1690000000"`), strings.Contains(query, `lt__gte: "100"`):
+			fmt.Fprint(w, `{"data":{"raw_transactions":[{"lt":"100","gen_utime__utc_unix":1700000000,"hash":"h1",
+				"out_msg_type":["int_msg_info","ext_out_msg_info"],"out_msg_body":["internal","log"]}]}}`)
+		default:
+			fmt.Fprint(w, `{"data":{"raw_transactions":[]}}`)
+		}
+	}))
+	defer server.Close()
+
+	source := indexer.NewDtonSource(server.URL)
+
+	transactions, err := source.PoolTransactions(context.Background(), config.PoolMain.Address, indexer.TxCursor{Utime: 1690000000}, 10)
+	if err != nil {
+		t.Fatalf("PoolTransactions: %v", err)
+	}
+	if len(transactions) != 1 {
+		t.Fatalf("want 1 transaction, got %d", len(transactions))
+	}
+	tx := transactions[0]
+	if tx.Hash != "h1" || tx.LT != 100 || tx.Utime != 1700000000 {
+		t.Errorf("unexpected transaction %+v", tx)
+	}
+	if len(tx.OutMsgBodies) != 1 || tx.OutMsgBodies[0] != "log" {
+		t.Errorf("want only ext-out bodies, got %v", tx.OutMsgBodies)
+	}
+
+	// lt__gte returns the cursor transaction again, it must be skipped
+	transactions, err = source.PoolTransactions(context.Background(), config.PoolMain.Address, indexer.TxCursor{Lt: 100, Hash: "h1"}, 10)
+	if err != nil {
+		t.Fatalf("PoolTransactions: %v", err)
+	}
+	if len(transactions) != 0 {
+		t.Errorf("want no transactions after the cursor, got %v", transactions)
+	}
+
+	state, err := source.AccountState(context.Background(), "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
+	if err != nil {
+		t.Fatalf("AccountState: %v", err)
+	}
+	if !bytes.Equal(state.Hash(), stateCell.Hash()) {
+		t.Errorf("unexpected state cell %s", state.Dump())
+	}
+
+	_, err = source.AccountState(context.Background(), "EQD1_i5tUQ-0SrKKRZf588f1CY8E9GDt20eNsH_01acgBiWE")
+	if !errors.Is(err, indexer.ErrAccountStateNotFound) {
+		t.Errorf("want ErrAccountStateNotFound, got %v", err)
+	}
+}
+
+func TestDtonSourceCanceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	source := indexer.NewDtonSource(server.URL)
+	done := make(chan error, 1)
+	go func() {
+		_, err := source.PoolTransactions(ctx, config.PoolMain.Address, indexer.TxCursor{Lt: 100}, 10)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("want deadline exceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request was not aborted")
+	}
 }
@@ -0,0 +1,163 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/health"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultReadyMaxCursorLag     = 30 * time.Minute
+	defaultReadyMaxDueUpdates    = 10000
+	defaultReadyMaxSourceFailure = 5 * time.Minute
+)
+
+var (
+	poolsSyncedMu sync.Mutex
+	// poolsSynced holds when the indexer of every pool last polled it up to the latest transaction
+	poolsSynced = make(map[string]time.Time)
+)
+
+// markPoolSynced records that the pool had no transactions left to index at the time.
+func markPoolSynced(pool string, at time.Time) {
+	poolsSyncedMu.Lock()
+	defer poolsSyncedMu.Unlock()
+	poolsSynced[pool] = at
+}
+
+// readiness defines when the indexer is considered not ready.
+type readiness struct {
+	maxCursorLag     time.Duration
+	maxDueUpdates    int64
+	maxSourceFailure time.Duration
+}
+
+func newReadiness(cfg config.Config) readiness {
+	r := readiness{
+		maxCursorLag:     defaultReadyMaxCursorLag,
+		maxDueUpdates:    defaultReadyMaxDueUpdates,
+		maxSourceFailure: defaultReadyMaxSourceFailure,
+	}
+	if cfg.ReadyMaxCursorLag > 0 {
+		r.maxCursorLag = time.Duration(cfg.ReadyMaxCursorLag) * time.Second
+	}
+	if cfg.ReadyMaxDueUpdates > 0 {
+		r.maxDueUpdates = int64(cfg.ReadyMaxDueUpdates)
+	}
+	if cfg.ReadyMaxSourceFailure > 0 {
+		r.maxSourceFailure = time.Duration(cfg.ReadyMaxSourceFailure) * time.Second
+	}
+
+	return r
+}
+
+// ReadinessChecks returns the checks the indexer is ready by: the database is reachable, cursors of
+// the pools are not behind by more than readyMaxCursorLag unless the pool has no newer transactions,
+// the update queue has no more than readyMaxDueUpdates due items and the data sources are not all
+// failing for readyMaxSourceFailure.
+func ReadinessChecks(db *gorm.DB, cfg config.Config, pools []config.Pool) []health.Check {
+	r := newReadiness(cfg)
+
+	return []health.Check{
+		health.DB(db),
+		{Name: "cursor_lag", Run: func(ctx context.Context) error {
+			return checkCursorLag(db.WithContext(ctx), pools, r.maxCursorLag, time.Now())
+		}},
+		{Name: "update_queue", Run: func(ctx context.Context) error {
+			return checkUpdateQueue(db.WithContext(ctx), r.maxDueUpdates)
+		}},
+		{Name: "data_source", Run: func(context.Context) error {
+			return checkSources(r.maxSourceFailure, time.Now())
+		}},
+	}
+}
+
+// checkCursorLag fails when a pool has no sync state or its last transaction is older than maxLag.
+// A pool this process polled up to its latest transaction within maxLag is not lagging, it has no
+// newer transactions to index.
+func checkCursorLag(db *gorm.DB, pools []config.Pool, maxLag time.Duration, now time.Time) error {
+	names := make([]string, 0, len(pools))
+	for _, pool := range pools {
+		names = append(names, pool.Name)
+	}
+
+	var states []config.OnchainSyncState
+	if err := db.Where("pool IN ?", names).Find(&states).Error; err != nil {
+		return fmt.Errorf("error per reading sync states: %w", err)
+	}
+	utimes := make(map[string]int64, len(states))
+	for _, state := range states {
+		utimes[state.Pool] = state.LastUtime
+	}
+
+	poolsSyncedMu.Lock()
+	synced := make(map[string]time.Time, len(poolsSynced))
+	for pool, at := range poolsSynced {
+		synced[pool] = at
+	}
+	poolsSyncedMu.Unlock()
+
+	var lagging []string
+	for _, name := range names {
+		utime, ok := utimes[name]
+		if !ok {
+			lagging = append(lagging, fmt.Sprintf("pool %s has no sync state", name))
+			continue
+		}
+		lag := now.Sub(time.Unix(utime, 0))
+		if lag <= maxLag {
+			continue
+		}
+		if at, ok := synced[name]; ok && now.Sub(at) <= maxLag {
+			continue
+		}
+		lagging = append(lagging, fmt.Sprintf("pool %s lags %s, more than %s", name, lag.Truncate(time.Second), maxLag))
+	}
+	if len(lagging) > 0 {
+		return errors.New(strings.Join(lagging, "; "))
+	}
+
+	return nil
+}
+
+// checkUpdateQueue fails when more than maxDue users are due to be updated.
+func checkUpdateQueue(db *gorm.DB, maxDue int64) error {
+	stats, err := GetQueueStats(db)
+	if err != nil {
+		return err
+	}
+	if stats.Due > maxDue {
+		return fmt.Errorf("%d users are due to be updated, more than %d", stats.Due, maxDue)
+	}
+
+	return nil
+}
+
+// checkSources fails when every data source has been failing for more than maxFailure,
+// while one of them works the indexer fails over to it.
+func checkSources(maxFailure time.Duration, now time.Time) error {
+	sourcesHealthMu.Lock()
+	sources := sourcesHealth
+	sourcesHealthMu.Unlock()
+
+	var failing []string
+	for _, s := range sources {
+		since, err := s.health.failing()
+		if since.IsZero() || now.Sub(since) <= maxFailure {
+			return nil
+		}
+		failing = append(failing, fmt.Sprintf("%s failing for %s: %v", s.name, now.Sub(since).Truncate(time.Second), err))
+	}
+	if len(failing) > 0 {
+		return errors.New(strings.Join(failing, "; "))
+	}
+
+	return nil
+}
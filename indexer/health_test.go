@@ -0,0 +1,134 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+)
+
+func TestCheckSources(t *testing.T) {
+	t.Cleanup(func() { trackSourcesHealth() })
+
+	primary := instrument(config.DataSourceDton, &fakeSource{err: errors.New("unavailable")})
+	fallback := instrument(config.DataSourceToncenter, &fakeSource{transactions: []ProcessedTransaction{{Hash: "h1"}}})
+	trackSourcesHealth(primary, fallback)
+
+	ctx := context.Background()
+	_, _ = primary.PoolTransactions(ctx, "pool", TxCursor{}, 10)
+	later := time.Now().Add(10 * time.Minute)
+
+	// the indexer fails over while one source works
+	if _, err := fallback.PoolTransactions(ctx, "pool", TxCursor{}, 10); err != nil {
+		t.Fatalf("PoolTransactions: %v", err)
+	}
+	if err := checkSources(5*time.Minute, later); err != nil {
+		t.Errorf("want ready with a working source, got %v", err)
+	}
+
+	fallback.source = &fakeSource{err: errors.New("rate limited")}
+	_, _ = fallback.PoolTransactions(ctx, "pool", TxCursor{}, 10)
+	if err := checkSources(5*time.Minute, time.Now()); err != nil {
+		t.Errorf("want ready until sources fail for long, got %v", err)
+	}
+	err := checkSources(5*time.Minute, later)
+	if err == nil || !strings.Contains(err.Error(), "dton failing") || !strings.Contains(err.Error(), "rate limited") {
+		t.Errorf("want both sources failing, got %v", err)
+	}
+
+	// a request abandoned by the indexer does not reset the failure
+	fallback.source = &fakeSource{err: context.Canceled}
+	_, _ = fallback.PoolTransactions(ctx, "pool", TxCursor{}, 10)
+	if err := checkSources(5*time.Minute, later); err == nil {
+		t.Error("want sources still failing")
+	}
+
+	fallback.source = &fakeSource{}
+	_, _ = fallback.AccountState(ctx, "addr")
+	if err := checkSources(5*time.Minute, later); err != nil {
+		t.Errorf("want ready after a source answered, got %v", err)
+	}
+}
+
+func TestNewReadiness(t *testing.T) {
+	r := newReadiness(config.Config{})
+	if r.maxCursorLag != defaultReadyMaxCursorLag || r.maxDueUpdates != defaultReadyMaxDueUpdates || r.maxSourceFailure != defaultReadyMaxSourceFailure {
+		t.Errorf("unexpected defaults %+v", r)
+	}
+
+	r = newReadiness(config.Config{ReadyMaxCursorLag: 60, ReadyMaxDueUpdates: 5, ReadyMaxSourceFailure: 120})
+	if r.maxCursorLag != time.Minute || r.maxDueUpdates != 5 || r.maxSourceFailure != 2*time.Minute {
+		t.Errorf("unexpected readiness %+v", r)
+	}
+}
+
+func TestCheckCursorLag(t *testing.T) {
+	db := testDB(t)
+
+	pools := []config.Pool{{Name: "test_lag_fresh"}, {Name: "test_lag_stale"}, {Name: "test_lag_missing"}, {Name: "test_lag_quiet"}}
+	names := []string{"test_lag_fresh", "test_lag_stale", "test_lag_missing", "test_lag_quiet"}
+	cleanup := func() {
+		db.Where("pool IN ?", names).Delete(&config.OnchainSyncState{})
+		poolsSyncedMu.Lock()
+		defer poolsSyncedMu.Unlock()
+		for _, name := range names {
+			delete(poolsSynced, name)
+		}
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	now := time.Now()
+	db.Create(&config.OnchainSyncState{Pool: "test_lag_fresh", LastUtime: now.Add(-time.Minute).Unix()})
+	db.Create(&config.OnchainSyncState{Pool: "test_lag_stale", LastUtime: now.Add(-time.Hour).Unix()})
+	// no transactions for an hour, but the last poll reached the latest one
+	db.Create(&config.OnchainSyncState{Pool: "test_lag_quiet", LastUtime: now.Add(-time.Hour).Unix()})
+	markPoolSynced("test_lag_quiet", now.Add(-time.Minute))
+	markPoolSynced("test_lag_stale", now.Add(-time.Hour))
+
+	if err := checkCursorLag(db, []config.Pool{pools[0], pools[3]}, 30*time.Minute, now); err != nil {
+		t.Errorf("want fresh and quiet pools ready, got %v", err)
+	}
+
+	err := checkCursorLag(db, pools, 30*time.Minute, now)
+	if err == nil {
+		t.Fatal("want lagging pools")
+	}
+	for _, want := range []string{"pool test_lag_stale lags 1h0m0s", "pool test_lag_missing has no sync state"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	for _, ready := range []string{"test_lag_fresh", "test_lag_quiet"} {
+		if strings.Contains(err.Error(), ready) {
+			t.Errorf("ready pool %s reported in %q", ready, err)
+		}
+	}
+}
+
+func TestProcessIndexMarksPoolSynced(t *testing.T) {
+	db := testDB(t)
+
+	pool := testPool(t, "test_synced_quiet")
+	cleanup := func() {
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainSyncState{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	// the last transaction of the pool is a day old, no new ones come
+	if err := db.Create(&config.OnchainSyncState{Pool: pool.Name, LastUtime: time.Now().Add(-24 * time.Hour).Unix()}).Error; err != nil {
+		t.Fatalf("cannot create sync state: %v", err)
+	}
+	SetChainSource(&fakeSource{})
+
+	if _, err := processIndex(config.Config{MaxPageSize: 10}, pool); err != nil {
+		t.Fatalf("processIndex: %v", err)
+	}
+	if err := checkCursorLag(db, []config.Pool{pool}, 30*time.Minute, time.Now()); err != nil {
+		t.Errorf("want a caught up pool without activity ready, got %v", err)
+	}
+}
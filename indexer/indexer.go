@@ -2,124 +2,65 @@ package indexer
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"math/big"
 	"reflect"
-	"strings"
 
 	//"os"
 	"sync"
 	"time"
 
-	"os"
-
-	sdkConfig "github.com/evaafi/evaa-go-sdk/config"
 	sdkPrincipal "github.com/evaafi/evaa-go-sdk/principal"
 	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/events"
+	"github.com/evaafi/go-indexer/metrics"
+	"github.com/evaafi/go-indexer/migrations"
+	"github.com/evaafi/go-indexer/tracing"
 	"github.com/xssnick/tonutils-go/address"
 	"github.com/xssnick/tonutils-go/tvm/cell"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
-type FutureUpdate struct {
-	Address         string
-	ContractAddress string
-	SubaccountID    int16
-	CreatedAt       int64
-	Pool            config.Pool
-	TxUtime         int64
-}
-
-type MapKey struct {
-	Address  string
-	PoolName string
-}
-
 var (
-	updateMap   sync.Map
-	updateQueue               = make(chan FutureUpdate, 30000)
-	sleepTime   time.Duration = 17
-	Shutdown                  = make(chan struct{})
-	WG          sync.WaitGroup
+	Shutdown = make(chan struct{})
+	WG       sync.WaitGroup
 )
 
-const queueFile = "update_queue.json"
-
 // reindexInterval defines how often we re-enqueue all users for background refresh
 const reindexInterval = 4 * time.Hour
 
-// reindexEnqueueDelay throttles how fast we push users into the queue to avoid bursts
-const reindexEnqueueDelay = 140 * time.Millisecond
-
-func SaveQueue() error {
-	var queueData []FutureUpdate
-
-	updateMap.Range(func(key, value interface{}) bool {
-		queueData = append(queueData, value.(FutureUpdate))
-		return true
-	})
-
-	data, err := json.Marshal(queueData)
-	if err != nil {
-		return fmt.Errorf("error encoding JSON: %w", err)
-	}
-
-	err = os.WriteFile(queueFile, data, 0644)
-	if err != nil {
-		return fmt.Errorf("error writing to file: %w", err)
-	}
-
-	fmt.Println("Queue successfully saved")
-	return nil
-}
-
-func LoadQueue() error {
-	if _, err := os.Stat(queueFile); os.IsNotExist(err) {
-		fmt.Println("Queue file not found, loading an empty queue")
-		return nil
-	}
+// logsBatchSize is how many logs are inserted by one statement
+var logsBatchSize = 1000
 
-	data, err := os.ReadFile(queueFile)
-	if err != nil {
-		return fmt.Errorf("error reading file: %w", err)
-	}
-
-	var queueData []FutureUpdate
-
-	err = json.Unmarshal(data, &queueData)
-	if err != nil {
-		return fmt.Errorf("error decoding JSON: %w", err)
-	}
-
-	for _, fut := range queueData {
-		updateMap.Store(MapKey{Address: fut.Address, PoolName: fut.Pool.Name}, fut)
-		updateQueue <- fut
-	}
+// reindexEnqueueDelay spreads refreshes of all users in time to avoid bursts
+const reindexEnqueueDelay = 140 * time.Millisecond
 
-	fmt.Printf("Loaded %d elements into the queue\n", len(queueData))
-	return nil
-}
+// messages logged for every user are sampled
+var (
+	userUpdatedLog config.Sampler
+	enqueueUserLog config.Sampler
+)
 
 func RunIndexer(ctx context.Context, cfg config.Config) {
-	if !cfg.ForceResyncOnEveryStart {
-		LoadQueue()
-	}
-
 	for i := 0; i < cfg.UserSyncWorkers; i++ {
 		WG.Add(1)
-		go worker(updateQueue, &WG)
+		go worker(newRetryPolicy(cfg), &WG)
 	}
 
 	for _, pool := range config.Pools {
-		fmt.Printf("starting %s indexer \n", pool.Name)
+		slog.Info("starting indexer", "pool", pool.Name)
 		go corutineIndexer(ctx, cfg, pool)
 	}
 
 	// start background reindex scheduler that gradually re-enqueues all users
 	go startReindexScheduler(ctx)
+
+	go recordQueueStats(ctx)
 }
 
 func corutineIndexer(ctx context.Context, cfg config.Config, pool config.Pool) {
@@ -133,7 +74,7 @@ func corutineIndexer(ctx context.Context, cfg config.Config, pool config.Pool) {
 		wait, err := processIndex(cfg, pool)
 
 		if err != nil {
-			fmt.Println(err)
+			slog.Error("error per indexing pool", "pool", pool.Name, "err", err)
 		}
 
 		if wait {
@@ -145,110 +86,223 @@ func corutineIndexer(ctx context.Context, cfg config.Config, pool config.Pool) {
 
 }
 
-func processIndex(cfg config.Config, pool config.Pool) (bool, error) {
+func processIndex(cfg config.Config, pool config.Pool) (wait bool, err error) {
 	var db, _ = config.GetDBInstance()
 
+	ctx, span := tracing.Start(context.Background(), "processIndex", trace.WithAttributes(tracing.Pool(pool.Name)))
+	defer func() { tracing.End(span, err) }()
+
 	var state config.OnchainSyncState
 	poolValue := pool.Name
 
 	if err := db.Where("pool = ?", poolValue).First(&state).Error; err != nil {
 		return false, fmt.Errorf("error per getting poolValue")
 	}
-	lastUtime := state.LastUtime
-	//fmt.Printf("pool %s: current utime %d\n", lastUtime);
+	metrics.SetCursor(pool.Name, state.LastUtime)
+
+	cursor := TxCursor{Lt: state.LastLt, Hash: state.LastHash, Utime: state.LastUtime}
+	span.SetAttributes(tracing.Lt(cursor.Lt), tracing.TxHash(cursor.Hash))
 	pageSize := cfg.MaxPageSize
-	transactions, lastUtime, err := ProcessTransactions(cfg.GraphQLEndpoint, pool.Address, lastUtime, pageSize)
+	transactions, err := chainSource.PoolTransactions(ctx, pool.Address, cursor, pageSize)
 
 	if err != nil {
-		return false, fmt.Errorf("error per processing transactions %s %d", pool.Name, lastUtime)
-	}
-
-	if len(transactions) == 0 && time.Now().Unix() > lastUtime+config.UtimeAddendum {
-		lastUtime += config.UtimeAddendum
-		if err := db.Save(&state).Error; err != nil {
-			return false, fmt.Errorf("error updating IdxSyncState: %w", err)
-		}
-
-		return true, nil
+		return false, fmt.Errorf("error per processing transactions %s %d:%s: %w", pool.Name, cursor.Lt, cursor.Hash, err)
 	}
 
 	if len(transactions) == 0 {
+		markPoolSynced(pool.Name, time.Now())
 		return true, nil
 	}
 
-	fmt.Printf("indexer %s got %d new transactions \n", pool.Name, len(transactions))
+	slog.Info("indexer got new transactions", "pool", pool.Name, "transactions", len(transactions), "lt", cursor.Lt, "hash", cursor.Hash)
+
+	last := transactions[len(transactions)-1]
+	state.LastLt = last.LT
+	state.LastHash = last.Hash
+	state.LastUtime = last.Utime
 
-	state.LastUtime = min(lastUtime, state.LastUtime+config.UtimeAddendum)
-	if err := db.Save(&state).Error; err != nil {
-		return false, fmt.Errorf("error updating IdxSyncState: %w", err)
+	page := parsePage(ctx, pool, transactions)
+	if err := page.commit(ctx, db, &state, false); err != nil {
+		return false, err
 	}
 
-	var logs []config.OnchainLog
+	slog.Info("pool page inserted", "pool", pool.Name, "logs", len(page.logs), "lt", state.LastLt, "hash", state.LastHash)
 
-	for _, tr := range transactions {
-		logVersion := 1
+	page.record(pool, len(transactions))
+	metrics.SetCursor(pool.Name, state.LastUtime)
 
-		if pool.Name == "lp" && tr.LT < 49712577000001 || pool.Name == "main" && tr.LT < 49828980000001 {
-			logVersion = 0
-		}
+	for _, idxLog := range page.logs {
+		events.Publish(events.LogIndexed{Log: idxLog})
+	}
+	events.Publish(events.CursorAdvanced{State: state})
 
-		for _, body := range tr.OutMsgBodies {
-			idxLog, err := ParseLogMessage(body, logVersion)
+	if len(transactions) < pageSize {
+		markPoolSynced(pool.Name, time.Now())
+	}
+	return len(transactions) >= pageSize, nil
+}
 
-			idxLog.Pool = pool.Name
-			idxLog.CreatedAt = time.Unix(idxLog.Utime, 0)
-			idxLog.Hash = tr.Hash
+// indexedPage holds the rows produced from a page of pool transactions.
+type indexedPage struct {
+	logs     []config.OnchainLog
+	unparsed []config.OnchainUnparsedLog
+	updates  []config.OnchainUpdateQueueItem
+}
+
+// parsePage decodes logs of the transactions and collects their users to update, the updates
+// are linked to the spans of their logs.
+func parsePage(ctx context.Context, pool config.Pool, transactions []ProcessedTransaction) indexedPage {
+	var page indexedPage
+	queued := make(map[string]bool)
 
+	for _, tr := range transactions {
+		for i, body := range tr.OutMsgBodies {
+			logCtx, span := tracing.Start(ctx, "ParseLogMessage", trace.WithAttributes(
+				tracing.Pool(pool.Name), tracing.TxHash(tr.Hash), tracing.Lt(tr.LT), attribute.Int("evaa.msg_index", tr.outMsgIndex(i))))
+			idxLog, err := ParseLogMessage(body, pool, tr.LT)
 			if err != nil {
-				if strings.Contains(err.Error(), "unknown log type") {
-					continue
-				}
-				fmt.Printf("cannot parse log message hash: %s %s \n", tr.Hash, err)
+				tracing.End(span, err)
+				slog.Warn("cannot parse log message", "pool", pool.Name, "hash", tr.Hash, "lt", tr.LT, "err", err)
+				page.unparsed = append(page.unparsed, newUnparsedLog(pool, tr, i, err))
 				continue
 			}
+			span.SetAttributes(tracing.Wallet(idxLog.UserAddress), tracing.Contract(idxLog.SenderAddress), attribute.String("evaa.tx_type", idxLog.TxType))
+			span.End()
 
-			logs = append(logs, idxLog)
+			idxLog.Pool = pool.Name
+			idxLog.CreatedAt = time.Unix(idxLog.Utime, 0)
+			idxLog.Hash = tr.Hash
+			idxLog.Lt = tr.LT
+			idxLog.MsgIndex = tr.outMsgIndex(i)
+			idxLog.Body = &body
 
-			key := MapKey{Address: idxLog.UserAddress, PoolName: pool.Name}
+			page.logs = append(page.logs, idxLog)
 
-			if _, ok := updateMap.Load(key); ok {
+			if queued[idxLog.SenderAddress] {
 				continue
 			}
+			queued[idxLog.SenderAddress] = true
 
-			fut := FutureUpdate{
-				Address:         idxLog.UserAddress,
+			// user state is read a bit later than the transaction to let data sources catch up
+			page.updates = append(page.updates, config.OnchainUpdateQueueItem{
+				Pool:            pool.Name,
 				ContractAddress: idxLog.SenderAddress,
+				UserAddress:     idxLog.UserAddress,
 				SubaccountID:    idxLog.SubaccountID,
-				CreatedAt:       time.Now().Unix(),
-				Pool:            pool,
 				TxUtime:         idxLog.Utime,
-			}
-			updateMap.Store(key, fut)
-			updateQueue <- fut
+				NextRunAt:       time.Unix(idxLog.Utime+updateDelayBufferSeconds, 0),
+				TraceParent:     tracing.TraceParent(logCtx),
+				CreatedAt:       time.Now(),
+			})
+		}
+	}
 
+	return page
+}
+
+// record counts the committed page in metrics.
+func (p indexedPage) record(pool config.Pool, transactions int) {
+	metrics.TransactionsIndexed.WithLabelValues(pool.Name).Add(float64(transactions))
+	for _, idxLog := range p.logs {
+		metrics.LogsIndexed.WithLabelValues(pool.Name, idxLog.TxType).Inc()
+	}
+	for _, unparsed := range p.unparsed {
+		opCode := "unknown"
+		if unparsed.OpCode != nil {
+			opCode = fmt.Sprintf("0x%x", *unparsed.OpCode)
 		}
+		metrics.ParseFailures.WithLabelValues(pool.Name, opCode).Inc()
 	}
+}
 
-	//fmt.Printf("%s pool start inserting\n", pool.Name)
+// commit stores the page and saves the cursor record (the sync state or the backfill progress)
+// in one database transaction, on a failure the cursor stays on the previous page and the same
+// transactions are processed again. Logs already stored are kept, unless replace is set.
+func (p indexedPage) commit(ctx context.Context, db *gorm.DB, cursor interface{}, replace bool) (err error) {
+	ctx, span := tracing.Start(ctx, "commitPage", trace.WithAttributes(
+		attribute.Int("evaa.logs", len(p.logs)), attribute.Int("evaa.unparsed_logs", len(p.unparsed)), attribute.Int("evaa.updates", len(p.updates))))
+	defer func() { tracing.End(span, err) }()
+	db = db.WithContext(ctx)
 
-	batchSize := 1000
+	onConflict := clause.OnConflict{DoNothing: true}
+	if replace {
+		onConflict = clause.OnConflict{UpdateAll: true}
+	}
 
-	for i := 0; i < len(logs); i += batchSize {
-		end := i + batchSize
-		if end > len(logs) {
-			end = len(logs)
+	if len(p.logs) > 0 {
+		from, to := p.logs[0].Utime, p.logs[0].Utime
+		for _, idxLog := range p.logs {
+			from, to = min(from, idxLog.Utime), max(to, idxLog.Utime)
+		}
+		if err := migrations.EnsureLogPartitions(db, from, to); err != nil {
+			return err
 		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for i := 0; i < len(p.logs); i += logsBatchSize {
+			end := min(i+logsBatchSize, len(p.logs))
+			batch := p.logs[i:end]
 
-		batch := logs[i:end]
+			if err := deleteLegacyLogs(tx, batch); err != nil {
+				return fmt.Errorf("error deleting legacy logs: %w", err)
+			}
 
-		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&batch).Error; err != nil {
-			return false, fmt.Errorf("error inserting records: %w", err)
+			_, batchSpan := tracing.Start(ctx, "insertLogsBatch", trace.WithAttributes(attribute.Int("evaa.logs", len(batch)),
+				tracing.TxHash(batch[0].Hash), attribute.String("evaa.last_tx_hash", batch[len(batch)-1].Hash)))
+			err := tx.Clauses(onConflict).Create(&batch).Error
+			tracing.End(batchSpan, err)
+			if err != nil {
+				return fmt.Errorf("error inserting records: %w", err)
+			}
 		}
+
+		if len(p.unparsed) > 0 {
+			if err := tx.Clauses(onConflict).CreateInBatches(&p.unparsed, logsBatchSize).Error; err != nil {
+				return fmt.Errorf("error inserting unparsed logs: %w", err)
+			}
+		}
+
+		if err := enqueueUpdates(tx, p.updates); err != nil {
+			return fmt.Errorf("error enqueueing user updates: %w", err)
+		}
+
+		if err := tx.Save(cursor).Error; err != nil {
+			return fmt.Errorf("error saving cursor: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// deleteLegacyLogs deletes logs of the batch transactions stored before msg_index was added, the
+// batch replaces them with every log of the transaction at its out message index.
+func deleteLegacyLogs(tx *gorm.DB, batch []config.OnchainLog) error {
+	keys := make([][]interface{}, 0, len(batch))
+	for _, idxLog := range batch {
+		keys = append(keys, []interface{}{idxLog.Hash, idxLog.Pool, idxLog.Utime})
 	}
+	return tx.Where("legacy AND (hash, pool, utime) IN ?", keys).Delete(&config.OnchainLog{}).Error
+}
 
-	fmt.Printf("%s pool inserted\n", pool.Name)
+// newUnparsedLog keeps the i-th log of the transaction which could not be decoded.
+func newUnparsedLog(pool config.Pool, tr ProcessedTransaction, i int, reason error) config.OnchainUnparsedLog {
+	unparsed := config.OnchainUnparsedLog{
+		Hash:      tr.Hash,
+		Pool:      pool.Name,
+		MsgIndex:  tr.outMsgIndex(i),
+		Lt:        tr.LT,
+		Utime:     tr.Utime,
+		Body:      tr.OutMsgBodies[i],
+		Error:     reason.Error(),
+		CreatedAt: time.Now(),
+	}
+	if opCode, err := LogOpCode(tr.OutMsgBodies[i]); err == nil {
+		op := int64(opCode)
+		unparsed.OpCode = &op
+	}
 
-	return len(transactions) >= pageSize, nil
+	return unparsed
 }
 
 func insertOrUpdate[T config.UserInterface](db *gorm.DB, data T) error {
@@ -305,109 +359,128 @@ func getUpdatableFields[T any](db *gorm.DB) []string {
 	return fields
 }
 
-func worker(updateQueue <-chan FutureUpdate, wg *sync.WaitGroup) {
+func worker(policy retryPolicy, wg *sync.WaitGroup) {
 	defer wg.Done()
+
+	db, _ := config.GetDBInstance()
+
 	for {
 		select {
 		case <-Shutdown:
-			fmt.Println("Worker received shutdown signal, finishing current task...")
+			slog.Info("worker received shutdown signal, finishing current task")
 			return
-		case fut := <-updateQueue:
-			makeUpdate(&fut)
+		default:
 		}
+
+		item, err := claimUpdate(db)
+		if err != nil {
+			slog.Error("cannot claim queue item", "err", err)
+		}
+		if item == nil {
+			select {
+			case <-Shutdown:
+			case <-time.After(queuePollInterval):
+			}
+			continue
+		}
+
+		processUpdate(db, item, policy)
 	}
 }
 
-func handleErrorAndRequeue(fut *FutureUpdate, reason string, err error) {
-	if err != nil {
-		fmt.Printf("%s: %v\n", reason, err)
-	} else {
-		fmt.Println(reason)
+// processUpdate refreshes the user of a claimed queue item and removes the item from the queue,
+// a failed item is rescheduled. It reports whether the user was updated.
+func processUpdate(db *gorm.DB, item *config.OnchainUpdateQueueItem, policy retryPolicy) bool {
+	metrics.UpdatesInFlight.Inc()
+	defer metrics.UpdatesInFlight.Dec()
+
+	// the span starts when the item became due, so it covers the wait in the queue,
+	// and is linked to the log which enqueued the user
+	start := time.Now()
+	dueAt := item.DueAt
+	if dueAt.IsZero() || dueAt.After(start) {
+		dueAt = start
+	}
+	opts := append(tracing.LinkTo(item.TraceParent), trace.WithTimestamp(dueAt), trace.WithAttributes(
+		tracing.Pool(item.Pool), tracing.Wallet(item.UserAddress), tracing.Contract(item.ContractAddress), attribute.Int("evaa.attempt", item.Attempts+1)))
+	ctx, span := tracing.Start(context.Background(), "processUpdate", opts...)
+	_, wait := tracing.Start(ctx, "queueWait", trace.WithTimestamp(dueAt))
+	wait.End(trace.WithTimestamp(start))
+
+	if err := makeUpdate(ctx, item); err != nil {
+		tracing.End(span, err)
+		metrics.UserUpdateDuration.WithLabelValues(item.Pool, metrics.OutcomeError).Observe(time.Since(start).Seconds())
+		slog.Warn("failed to update user", "pool", item.Pool, "wallet", item.UserAddress, "contract", item.ContractAddress, "attempt", item.Attempts+1, "err", err)
+		dead, err := failUpdate(db, item, err, policy)
+		if err != nil {
+			slog.Error("error per rescheduling queue item", "pool", item.Pool, "wallet", item.UserAddress, "id", item.ID, "err", err)
+		} else if dead {
+			metrics.UserUpdateDeadLetters.WithLabelValues(item.Pool).Inc()
+			slog.Warn("user moved to dead letters", "pool", item.Pool, "wallet", item.UserAddress, "contract", item.ContractAddress, "attempts", item.Attempts+1)
+		} else {
+			metrics.UserUpdateRetries.WithLabelValues(item.Pool).Inc()
+		}
+		return false
 	}
+	metrics.UserUpdateDuration.WithLabelValues(item.Pool, metrics.OutcomeOK).Observe(time.Since(start).Seconds())
 
-	if fut != nil {
-		key := MapKey{Address: fut.Address, PoolName: fut.Pool.Name}
+	span.End()
 
-		updateMap.Store(key, fut)
-		updateQueue <- *fut
+	if err := completeUpdate(db, item); err != nil {
+		slog.Error("cannot complete queue item", "pool", item.Pool, "wallet", item.UserAddress, "err", err)
 	}
+	return true
 }
 
 const updateDelayBufferSeconds int64 = 17
 
-func makeUpdate(fut *FutureUpdate) {
-	//update := fut.CreatedAt
-	if fut.TxUtime > time.Now().Unix()-updateDelayBufferSeconds {
-		time.Sleep(sleepTime * time.Second)
-	}
-
-	key := MapKey{Address: fut.Address, PoolName: fut.Pool.Name}
-	defer updateMap.Delete(key)
-
+func makeUpdate(ctx context.Context, item *config.OnchainUpdateQueueItem) error {
 	db, _ := config.GetDBInstance()
 
-	var userContractAddress = address.MustParseAddr(fut.ContractAddress)
-	var sdkPoolConfig *sdkConfig.Config
-
-	if fut.Pool.Name == "main" {
-		sdkPoolConfig = sdkConfig.GetMainMainnetConfig()
-	} else if fut.Pool.Name == "lp" {
-		sdkPoolConfig = sdkConfig.GetLpMainnetConfig()
-	} else if fut.Pool.Name == "alts" {
-		sdkPoolConfig = sdkConfig.GetAltsMainnetConfig()
-	} else if fut.Pool.Name == "stable" {
-		sdkPoolConfig = sdkConfig.GetStableMainnetConfig()
-	}
-	//userContractAddress, _ = service.CalculateUserSCAddress(address.MustParseAddr(fut.Address))
-
-	rawState, err := GetRawState(config.CFG.GraphQLEndpoint, userContractAddress.String())
-
+	userContractAddress, err := address.ParseAddr(item.ContractAddress)
 	if err != nil {
-		handleErrorAndRequeue(fut, "failed to get user state", err)
-		return
+		return fmt.Errorf("invalid contract address: %w", err)
 	}
-
-	var userStateResponse GraphQLStatesResponse
-	if err := json.Unmarshal([]byte(rawState), &userStateResponse); err != nil {
-		handleErrorAndRequeue(fut, fmt.Sprintf("failed to unmarshal user state %s", rawState), err)
-		return
+	sdkPoolConfig := getSDKPoolConfig(item.Pool)
+	if sdkPoolConfig == nil {
+		return fmt.Errorf("unknown pool %s", item.Pool)
 	}
+	//userContractAddress, _ = service.CalculateUserSCAddress(address.MustParseAddr(item.UserAddress))
 
-	if len(userStateResponse.Data.RawAccountStates) == 0 {
-		handleErrorAndRequeue(fut, fmt.Sprintf("cannot get user state: %s %s %s %s, %s; adding again to queue", fut.Address, userContractAddress.String(), fut.Pool, rawState, userStateResponse.Data), nil)
-		return
+	data, err := chainSource.AccountState(ctx, userContractAddress.String())
+
+	if errors.Is(err, ErrAccountStateNotFound) {
+		return fmt.Errorf("cannot get user state: %w", err)
 	}
 
-	dataBoc, err := base64.StdEncoding.DecodeString(userStateResponse.Data.RawAccountStates[0].State)
-	if err != nil {
-		handleErrorAndRequeue(fut, fmt.Sprintf("failed to decode base64 user state: %s %s", userStateResponse.Data.RawAccountStates[0].State, userContractAddress.String()), err)
-		return
+	if errors.Is(err, ErrStateMismatch) {
+		return fmt.Errorf("user state differs between data sources: %w", err)
 	}
 
-	data, err := cell.FromBOC(dataBoc)
 	if err != nil {
-		handleErrorAndRequeue(fut, fmt.Sprintf("failed to create boc from base64 user state: %s", userContractAddress.String()), err)
-		return
+		return fmt.Errorf("failed to get user state: %w", err)
 	}
 
 	user := sdkPrincipal.NewUserSC(userContractAddress)
-	_, _ = user.SetAccData(data)
+	if err := setAccData(user, data); err != nil {
+		return fmt.Errorf("cannot parse user state: %w", err)
+	}
 
 	onchainUser := config.OnchainUser{}
 
 	userPrincipals := user.Principals()
-	onchainUser.Pool = fut.Pool.Name
-	onchainUser.SubaccountID = fut.SubaccountID
+	onchainUser.Pool = item.Pool
+	onchainUser.SubaccountID = item.SubaccountID
 	onchainUser.CodeVersion = int(user.CodeVersion())
 	onchainUser.ContractAddress = userContractAddress.String()
 	onchainUser.State = config.BigInt{Int: big.NewInt(user.UserState())}
-	if fut.CreatedAt > (fut.TxUtime + updateDelayBufferSeconds) {
-		onchainUser.UpdatedAt = time.Unix(fut.CreatedAt, 0)
+	if item.CreatedAt.Unix() > (item.TxUtime + updateDelayBufferSeconds) {
+		onchainUser.UpdatedAt = item.CreatedAt
 	} else {
-		onchainUser.UpdatedAt = time.Unix(fut.TxUtime, 0)
+		onchainUser.UpdatedAt = time.Unix(item.TxUtime, 0)
 	}
-	onchainUser.CreatedAt = time.Unix(fut.TxUtime, 0)
-	onchainUser.WalletAddress = fut.Address
+	onchainUser.CreatedAt = time.Unix(item.TxUtime, 0)
+	onchainUser.WalletAddress = item.UserAddress
 
 	principalsByID := make(map[string]*big.Int)
 	for name, raw := range userPrincipals {
@@ -428,17 +501,100 @@ func makeUpdate(fut *FutureUpdate) {
 	}
 	onchainUser.Principals = normalizedPrincipals
 
-	if err := insertOrUpdate(db, onchainUser); err != nil {
-		fmt.Printf("error per insertOrUpdate  %s\n", err)
-	} else {
-		fmt.Printf("user updated: wallet=%s pool=%s sub=%d contract=%s updated_at=%s\n",
-			onchainUser.WalletAddress,
-			onchainUser.Pool,
-			onchainUser.SubaccountID,
-			onchainUser.ContractAddress,
-			onchainUser.UpdatedAt.Format(time.RFC3339),
-		)
+	_, span := tracing.Start(ctx, "insertOrUpdate", trace.WithAttributes(
+		tracing.Pool(onchainUser.Pool), tracing.Wallet(onchainUser.WalletAddress), tracing.Contract(onchainUser.ContractAddress)))
+	err = insertOrUpdate(db.WithContext(ctx), onchainUser)
+	tracing.End(span, err)
+	if err != nil {
+		return fmt.Errorf("error per insertOrUpdate: %w", err)
+	}
+	events.Publish(events.UserUpdated{User: onchainUser})
+
+	userUpdatedLog.Info("user updated",
+		"pool", onchainUser.Pool,
+		"wallet", onchainUser.WalletAddress,
+		"sub", onchainUser.SubaccountID,
+		"contract", onchainUser.ContractAddress,
+		"updated_at", onchainUser.UpdatedAt,
+	)
+
+	return nil
+}
+
+// setAccData loads the user contract data, the SDK panics on a truncated or uninitialised one.
+func setAccData(user *sdkPrincipal.UserSC, data *cell.Cell) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed user data: %v", r)
+		}
+	}()
+
+	_, err = user.SetAccData(data)
+	return err
+}
+
+// RefreshUser reads the user state from the chain source and stores it right away, without the
+// update queue. The user contract is taken from the indexed users, a user not indexed yet is
+// refreshed by the contract address calculated for the wallet, which is known for subaccount 0 only.
+func RefreshUser(pool config.Pool, walletAddress string, subaccountID int16) (*config.OnchainUser, error) {
+	db, _ := config.GetDBInstance()
+
+	wallet, err := normalizeWallet(walletAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	var contractAddress string
+	var user config.OnchainUser
+	err = db.Where("pool = ? AND wallet_address = ? AND subaccount_id = ?", pool.Name, wallet.String(), subaccountID).
+		Take(&user).Error
+	switch {
+	case err == nil:
+		contractAddress = user.ContractAddress
+	case errors.Is(err, gorm.ErrRecordNotFound) && subaccountID == 0:
+		sdkPoolConfig := getSDKPoolConfig(pool.Name)
+		if sdkPoolConfig == nil {
+			return nil, fmt.Errorf("unknown pool %s", pool.Name)
+		}
+		calculated, err := sdkPrincipal.NewService(sdkPoolConfig).CalculateUserSCAddress(wallet)
+		if err != nil {
+			return nil, fmt.Errorf("error per calculating user contract address: %w", err)
+		}
+		contractAddress = calculated.String()
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, fmt.Errorf("user %s subaccount %d is not indexed in %s pool", wallet.String(), subaccountID, pool.Name)
+	default:
+		return nil, fmt.Errorf("error per reading user: %w", err)
+	}
+
+	item := config.OnchainUpdateQueueItem{
+		Pool:            pool.Name,
+		ContractAddress: contractAddress,
+		UserAddress:     wallet.String(),
+		SubaccountID:    subaccountID,
+		TxUtime:         time.Now().Unix(),
+		CreatedAt:       time.Now(),
 	}
+	if err := makeUpdate(context.Background(), &item); err != nil {
+		return nil, err
+	}
+
+	if err := db.Where("contract_address = ?", contractAddress).Take(&user).Error; err != nil {
+		return nil, fmt.Errorf("error per reading user: %w", err)
+	}
+	return &user, nil
+}
+
+// normalizeWallet parses a user-friendly or raw wallet address into the bounceable mainnet form
+// users are stored by.
+func normalizeWallet(s string) (*address.Address, error) {
+	wallet, err := address.ParseAddr(s)
+	if err != nil {
+		if wallet, err = address.ParseRawAddr(s); err != nil {
+			return nil, fmt.Errorf("invalid wallet address %s", s)
+		}
+	}
+	return address.NewAddress(0, byte(wallet.Workchain()), wallet.Data()), nil
 }
 
 // getPoolByName returns pool config by name
@@ -452,7 +608,7 @@ func getPoolByName(name string) (config.Pool, bool) {
 }
 
 // startReindexScheduler runs a periodic job which, on startup and every reindexInterval,
-// iterates through all users in the DB and gradually enqueues them for refresh via makeUpdate.
+// iterates through all users in the DB and enqueues them for gradual refresh via makeUpdate.
 func startReindexScheduler(ctx context.Context) {
 	// run once immediately on startup
 	go func() { enqueueAllUsersGradually(ctx) }()
@@ -495,58 +651,69 @@ FROM %s u
 LEFT JOIN %s l
   ON l.user_address = u.wallet_address AND l.pool = u.pool AND l.subaccount_id = u.subaccount_id
 GROUP BY u.wallet_address, u.pool, u.subaccount_id, u.contract_address, u.updated_at
-ORDER BY (COUNT(l.hash) = 0) DESC, MAX(l.utime) DESC, u.wallet_address ASC
+ORDER BY (MAX(l.utime) IS NULL) DESC, MAX(l.utime) DESC, u.wallet_address ASC
 LIMIT ? OFFSET ?`, usersTable, logsTable)
 
+	metrics.ReindexRunning.Set(1)
+	metrics.ReindexEnqueued.Set(0)
+	defer func() {
+		metrics.ReindexRunning.Set(0)
+		metrics.ReindexFinished.SetToCurrentTime()
+	}()
+
+	runAt := time.Now()
 	batchSize := 500
 	for offset := 0; ; offset += batchSize {
 		var rows []orderedUser
 		if err := db.Raw(query, batchSize, offset).Scan(&rows).Error; err != nil {
-			fmt.Printf("scheduler query error: %v\n", err)
+			slog.Error("scheduler query error", "err", err)
 			return
 		}
 		if len(rows) == 0 {
 			return
 		}
-		for _, u := range rows {
-			select {
-			case <-ctx.Done():
-				return
-			case <-Shutdown:
-				return
-			default:
-			}
+		select {
+		case <-ctx.Done():
+			return
+		case <-Shutdown:
+			return
+		default:
+		}
 
+		items := make([]config.OnchainUpdateQueueItem, 0, len(rows))
+		for _, u := range rows {
 			pool, ok := getPoolByName(u.Pool)
 			if !ok {
 				continue
 			}
-			key := MapKey{Address: u.WalletAddress, PoolName: pool.Name}
-			if _, exists := updateMap.Load(key); exists {
-				continue
-			}
-			fut := FutureUpdate{
-				Address:         u.WalletAddress,
+
+			// users are spread in time in the order of priority
+			runAt = runAt.Add(reindexEnqueueDelay)
+			items = append(items, config.OnchainUpdateQueueItem{
+				Pool:            pool.Name,
 				ContractAddress: u.ContractAddress,
+				UserAddress:     u.WalletAddress,
 				SubaccountID:    u.SubaccountID,
-				CreatedAt:       time.Now().Unix(),
-				Pool:            pool,
 				TxUtime:         u.UpdatedAt.Unix(),
+				NextRunAt:       runAt,
+				CreatedAt:       time.Now(),
+			})
+
+			if u.LastUtime == 0 {
+				enqueueUserLog.Info("enqueue user", "pool", pool.Name, "wallet", u.WalletAddress, "sub", u.SubaccountID, "priority", "no_tx")
+			} else {
+				enqueueUserLog.Info("enqueue user", "pool", pool.Name, "wallet", u.WalletAddress, "sub", u.SubaccountID, "priority", "recent",
+					"last_activity", time.Unix(u.LastUtime, 0))
 			}
-			updateMap.Store(key, fut)
-			select {
-			case updateQueue <- fut:
-				if u.LastUtime == 0 {
-					fmt.Printf("enqueue user: wallet=%s pool=%s sub=%d priority=no_tx last_activity=-\n", u.WalletAddress, pool.Name, u.SubaccountID)
-				} else {
-					fmt.Printf("enqueue user: wallet=%s pool=%s sub=%d priority=recent last_activity=%s\n", u.WalletAddress, pool.Name, u.SubaccountID, time.Unix(u.LastUtime, 0).Format(time.RFC3339))
-				}
-			case <-ctx.Done():
-				return
-			case <-Shutdown:
+		}
+
+		// users already waiting in the queue keep their place
+		if len(items) > 0 {
+			if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&items).Error; err != nil {
+				slog.Error("scheduler enqueue error", "err", err)
 				return
 			}
-			time.Sleep(reindexEnqueueDelay)
+			metrics.ReindexEnqueued.Add(float64(len(items)))
 		}
 	}
 }
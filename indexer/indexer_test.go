@@ -0,0 +1,324 @@
+package indexer
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	sdkPrincipal "github.com/evaafi/evaa-go-sdk/principal"
+	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/migrations"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+)
+
+// crashEnv makes the test process exit in the middle of the logs commit
+const crashEnv = "INDEXER_TEST_CRASH_MID_BATCH"
+
+// testDB connects to the database from ../config.yaml, tests are skipped when it is not available.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	cfg, err := config.LoadConfig("../config.yaml")
+	if err != nil {
+		t.Skipf("no test config: %v", err)
+	}
+	config.CFG = cfg
+
+	db, err := config.GetDBInstance()
+	if err != nil {
+		t.Skipf("database is not available: %v", err)
+	}
+
+	if _, err := migrations.Up(db, migrations.Options{}); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+
+	return db
+}
+
+// testPool returns a pool emitting LogVersion2 logs from its first transaction, it is added
+// to the registry until the test ends.
+func testPool(t *testing.T, name string) config.Pool {
+	t.Helper()
+
+	pool := config.Pool{
+		Name:        name,
+		Address:     config.PoolMain.Address,
+		LogVersions: []config.LogVersionBoundary{{Version: LogVersion2, FromLt: 0}},
+	}
+	previous := config.Pools
+	config.Pools = append(slices.Clone(previous), pool)
+	t.Cleanup(func() { config.Pools = previous })
+
+	return pool
+}
+
+func supplyLogBody(user *address.Address, utime uint32) string {
+	assetData := cell.BeginCell().
+		MustStoreUInt(1, 256).
+		MustStoreUInt(100, 64).
+		MustStoreInt(100, 64).
+		MustStoreInt(1000, 64).
+		MustStoreInt(500, 64).
+		MustStoreUInt(1, 64).
+		MustStoreUInt(1, 64).
+		EndCell()
+
+	log := cell.BeginCell().
+		MustStoreUInt(LogOpCodeSupplySuccess, 8).
+		MustStoreAddr(user).
+		MustStoreAddr(user).
+		MustStoreUInt(uint64(utime), 32).
+		MustStoreInt(0, 16).
+		MustStoreRef(assetData).
+		EndCell()
+
+	return base64.StdEncoding.EncodeToString(log.ToBOC())
+}
+
+func TestProcessIndexCrashMidBatch(t *testing.T) {
+	db := testDB(t)
+
+	pool := testPool(t, "test_crash")
+	users := []*address.Address{
+		address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa"),
+		address.MustParseAddr("EQD1_i5tUQ-0SrKKRZf588f1CY8E9GDt20eNsH_01acgBiWE"),
+		address.MustParseAddr("EQBR2DQ0olmo0QEFeELUWcnGnAJXeqTV4ZKqM3MFnpsJRg51"),
+	}
+
+	var transactions []ProcessedTransaction
+	for i, user := range users {
+		utime := 1700000000 + i
+		transactions = append(transactions, ProcessedTransaction{
+			Hash:         fmt.Sprintf("A%d", i+1),
+			LT:           int64(100 + i),
+			Utime:        int64(utime),
+			OutMsgBodies: []string{supplyLogBody(user, uint32(utime))},
+		})
+	}
+	// a log nobody can decode yet is kept for reparsing
+	unknownLog := base64.StdEncoding.EncodeToString(cell.BeginCell().MustStoreUInt(0x77, 8).EndCell().ToBOC())
+	transactions[2].OutMsgBodies = append(transactions[2].OutMsgBodies, unknownLog)
+
+	SetChainSource(&fakeSource{transactions: transactions})
+	logsBatchSize = 1
+	defer func() { logsBatchSize = 1000 }()
+	cfg := config.Config{MaxPageSize: 10}
+
+	if os.Getenv(crashEnv) != "" {
+		logsTable := config.GetTableName(db, &config.OnchainLog{})
+		// the process dies right after the first logs batch is written
+		_ = db.Callback().Create().After("gorm:create").Register("test:crash", func(tx *gorm.DB) {
+			if tx.Statement.Table == logsTable {
+				os.Exit(3)
+			}
+		})
+		_, _ = processIndex(cfg, pool)
+		t.Fatal("process was not killed")
+	}
+
+	cleanup := func() {
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainLog{})
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainUpdateQueueItem{})
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainSyncState{})
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainUnparsedLog{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	if err := db.Create(&config.OnchainSyncState{Pool: pool.Name, LastUtime: 1690000000}).Error; err != nil {
+		t.Fatalf("cannot create sync state: %v", err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestProcessIndexCrashMidBatch$")
+	cmd.Env = append(os.Environ(), crashEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("want process killed mid batch, got %v: %s", err, out)
+	}
+
+	var logsCount, queueCount, unparsedCount int64
+	var state config.OnchainSyncState
+	db.Model(&config.OnchainLog{}).Where("pool = ?", pool.Name).Count(&logsCount)
+	db.Model(&config.OnchainUpdateQueueItem{}).Where("pool = ?", pool.Name).Count(&queueCount)
+	db.Model(&config.OnchainUnparsedLog{}).Where("pool = ?", pool.Name).Count(&unparsedCount)
+	db.Where("pool = ?", pool.Name).First(&state)
+	if logsCount != 0 || queueCount != 0 || unparsedCount != 0 || state.LastLt != 0 {
+		t.Fatalf("partial commit after crash: %d logs, %d queued users, %d unparsed logs, cursor %d",
+			logsCount, queueCount, unparsedCount, state.LastLt)
+	}
+
+	// after restart the same page is processed completely
+	if _, err := processIndex(cfg, pool); err != nil {
+		t.Fatalf("processIndex: %v", err)
+	}
+
+	db.Model(&config.OnchainLog{}).Where("pool = ?", pool.Name).Count(&logsCount)
+	db.Model(&config.OnchainUpdateQueueItem{}).Where("pool = ?", pool.Name).Count(&queueCount)
+	db.Model(&config.OnchainUnparsedLog{}).Where("pool = ?", pool.Name).Count(&unparsedCount)
+	db.Where("pool = ?", pool.Name).First(&state)
+	if logsCount != int64(len(users)) {
+		t.Errorf("want %d logs, got %d", len(users), logsCount)
+	}
+	if queueCount != int64(len(users)) {
+		t.Errorf("want %d queued users, got %d", len(users), queueCount)
+	}
+	if unparsedCount != 1 {
+		t.Errorf("want the unknown log kept, got %d unparsed logs", unparsedCount)
+	}
+	if state.LastLt != 102 || state.LastHash != "A3" {
+		t.Errorf("want cursor on the last transaction, got %d:%s", state.LastLt, state.LastHash)
+	}
+}
+
+// multiLogTransaction emits an internal message and a supply log for each user
+func multiLogTransaction(users ...*address.Address) ProcessedTransaction {
+	tr := ProcessedTransaction{Hash: "M1", LT: 200, Utime: 1700000100}
+	for i, user := range users {
+		tr.OutMsgBodies = append(tr.OutMsgBodies, supplyLogBody(user, uint32(tr.Utime)))
+		tr.OutMsgIndexes = append(tr.OutMsgIndexes, i+1)
+	}
+	return tr
+}
+
+func TestParsePageMultipleLogs(t *testing.T) {
+	pool := testPool(t, "test_multi_log")
+	tr := multiLogTransaction(
+		address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa"),
+		address.MustParseAddr("EQD1_i5tUQ-0SrKKRZf588f1CY8E9GDt20eNsH_01acgBiWE"),
+	)
+
+	page := parsePage(context.Background(), pool, []ProcessedTransaction{tr})
+	if len(page.logs) != 2 {
+		t.Fatalf("want 2 logs, got %d", len(page.logs))
+	}
+	for i, idxLog := range page.logs {
+		if idxLog.Hash != tr.Hash || idxLog.MsgIndex != tr.OutMsgIndexes[i] {
+			t.Errorf("log %d: want %s/%d, got %s/%d", i, tr.Hash, tr.OutMsgIndexes[i], idxLog.Hash, idxLog.MsgIndex)
+		}
+	}
+	if len(page.updates) != 2 {
+		t.Errorf("want both users queued, got %d", len(page.updates))
+	}
+}
+
+func TestProcessIndexMultipleLogs(t *testing.T) {
+	db := testDB(t)
+
+	pool := testPool(t, "test_multi_log")
+	cleanup := func() {
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainLog{})
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainUpdateQueueItem{})
+		db.Where("pool = ?", pool.Name).Delete(&config.OnchainSyncState{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	tr := multiLogTransaction(
+		address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa"),
+		address.MustParseAddr("EQD1_i5tUQ-0SrKKRZf588f1CY8E9GDt20eNsH_01acgBiWE"),
+	)
+	SetChainSource(&fakeSource{transactions: []ProcessedTransaction{tr}})
+	if err := db.Create(&config.OnchainSyncState{Pool: pool.Name, LastUtime: 1690000000}).Error; err != nil {
+		t.Fatalf("cannot create sync state: %v", err)
+	}
+
+	if _, err := processIndex(config.Config{MaxPageSize: 10}, pool); err != nil {
+		t.Fatalf("processIndex: %v", err)
+	}
+
+	var logs []config.OnchainLog
+	db.Where("pool = ?", pool.Name).Order("msg_index").Find(&logs)
+	if len(logs) != 2 {
+		t.Fatalf("want both logs of the transaction, got %d", len(logs))
+	}
+	if logs[0].MsgIndex != 1 || logs[1].MsgIndex != 2 {
+		t.Errorf("want message indexes 1 and 2, got %d and %d", logs[0].MsgIndex, logs[1].MsgIndex)
+	}
+}
+
+func TestParsePageTraces(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	pool := testPool(t, "test_traces")
+	wallet := address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
+	tr := multiLogTransaction(wallet)
+	tr.OutMsgBodies = append(tr.OutMsgBodies, "broken")
+	tr.OutMsgIndexes = append(tr.OutMsgIndexes, 2)
+
+	page := parsePage(context.Background(), pool, []ProcessedTransaction{tr})
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("want a span per log, got %d", len(spans))
+	}
+	parsed, failed := spans[0], spans[1]
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range parsed.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["evaa.tx_hash"].AsString() != tr.Hash || attrs["evaa.lt"].AsInt64() != tr.LT || attrs["evaa.wallet"].AsString() != page.logs[0].UserAddress {
+		t.Errorf("unexpected attributes %v", parsed.Attributes())
+	}
+	if failed.Status().Code != codes.Error {
+		t.Errorf("want failed parse span, got %+v", failed.Status())
+	}
+
+	// the user refresh is linked to the log which enqueued it
+	if len(page.updates) != 1 || !strings.Contains(page.updates[0].TraceParent, parsed.SpanContext().SpanID().String()) {
+		t.Errorf("want update linked to span %s, got %+v", parsed.SpanContext().SpanID(), page.updates)
+	}
+}
+
+func TestSetAccDataMalformed(t *testing.T) {
+	user := sdkPrincipal.NewUserSC(address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa"))
+
+	// an uninitialised contract has the code version only
+	data := cell.BeginCell().MustStoreCoins(7).EndCell()
+	if err := setAccData(user, data); err == nil {
+		t.Fatal("want error for malformed user data")
+	}
+}
+
+func TestNormalizeWallet(t *testing.T) {
+	want := "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa"
+	wallet := address.MustParseAddr(want)
+
+	forms := []string{
+		want,
+		wallet.Bounce(false).String(),
+		wallet.Testnet(true).String(),
+		wallet.StringRaw(),
+	}
+	for _, form := range forms {
+		got, err := normalizeWallet(form)
+		if err != nil {
+			t.Fatalf("%s: %v", form, err)
+		}
+		if got.String() != want {
+			t.Errorf("%s: want %s, got %s", form, want, got.String())
+		}
+	}
+
+	if _, err := normalizeWallet("not an address"); err == nil {
+		t.Error("want error for invalid wallet")
+	}
+}
@@ -0,0 +1,130 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/metrics"
+	"github.com/evaafi/go-indexer/tracing"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// outcomeNotFound is the outcome of account state requests for accounts the source has no state of,
+	// they are answered and are not counted as errors
+	outcomeNotFound = "not_found"
+	// outcomeCanceled is the outcome of requests abandoned by the indexer, they tell nothing about the source
+	outcomeCanceled = "canceled"
+)
+
+// sourceHealth tracks since when requests to a data source fail, zero while they succeed.
+type sourceHealth struct {
+	mu           sync.Mutex
+	failingSince time.Time
+	lastError    error
+}
+
+func (h *sourceHealth) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err == nil {
+		h.failingSince = time.Time{}
+		h.lastError = nil
+		return
+	}
+	if h.failingSince.IsZero() {
+		h.failingSince = time.Now()
+	}
+	h.lastError = err
+}
+
+func (h *sourceHealth) failing() (time.Time, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.failingSince, h.lastError
+}
+
+var (
+	sourcesHealthMu sync.Mutex
+	// sourcesHealth holds the health of the sources of the last created chain source, in order
+	sourcesHealth []namedHealth
+)
+
+type namedHealth struct {
+	name   config.DataSource
+	health *sourceHealth
+}
+
+// instrumentedSource records latency and errors of requests to a data source in metrics
+// and tracks its health for readiness.
+type instrumentedSource struct {
+	name   config.DataSource
+	source ChainSource
+	health *sourceHealth
+}
+
+func instrument(name config.DataSource, source ChainSource) *instrumentedSource {
+	if name == "" {
+		name = config.DataSourceDton
+	}
+	return &instrumentedSource{name: name, source: source, health: &sourceHealth{}}
+}
+
+// trackSourcesHealth makes the sources the ones the readiness check looks at.
+func trackSourcesHealth(sources ...*instrumentedSource) {
+	sourcesHealthMu.Lock()
+	defer sourcesHealthMu.Unlock()
+
+	sourcesHealth = nil
+	for _, s := range sources {
+		sourcesHealth = append(sourcesHealth, namedHealth{name: s.name, health: s.health})
+	}
+}
+
+func (s *instrumentedSource) PoolTransactions(ctx context.Context, poolAddress string, after TxCursor, limit int) ([]ProcessedTransaction, error) {
+	ctx, span := tracing.Start(ctx, "ChainSource.PoolTransactions", trace.WithAttributes(attribute.String("evaa.source", string(s.name)),
+		tracing.Contract(poolAddress), tracing.Lt(after.Lt), tracing.TxHash(after.Hash), attribute.Int("evaa.limit", limit)))
+	start := time.Now()
+	transactions, err := s.source.PoolTransactions(ctx, poolAddress, after, limit)
+	s.observe("pool_transactions", start, err)
+	span.SetAttributes(attribute.Int("evaa.transactions", len(transactions)))
+	tracing.End(span, err)
+
+	return transactions, err
+}
+
+func (s *instrumentedSource) AccountState(ctx context.Context, address string) (*cell.Cell, error) {
+	ctx, span := tracing.Start(ctx, "ChainSource.AccountState", trace.WithAttributes(attribute.String("evaa.source", string(s.name)),
+		tracing.Contract(address)))
+	start := time.Now()
+	data, err := s.source.AccountState(ctx, address)
+	s.observe("account_state", start, err)
+	tracing.End(span, err)
+
+	return data, err
+}
+
+func (s *instrumentedSource) observe(method string, start time.Time, err error) {
+	outcome := metrics.OutcomeOK
+	switch {
+	case errors.Is(err, ErrAccountStateNotFound):
+		outcome = outcomeNotFound
+		s.health.record(nil)
+	case errors.Is(err, context.Canceled):
+		outcome = outcomeCanceled
+	case err != nil:
+		outcome = metrics.OutcomeError
+		metrics.SourceRequestErrors.WithLabelValues(string(s.name), method).Inc()
+		s.health.record(err)
+	default:
+		s.health.record(nil)
+	}
+
+	metrics.SourceRequestDuration.WithLabelValues(string(s.name), method, outcome).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,70 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+func TestInstrumentedSource(t *testing.T) {
+	name := string(config.DataSourceToncenter)
+	errorsBefore := testutil.ToFloat64(metrics.SourceRequestErrors.WithLabelValues(name, "account_state"))
+
+	source := instrument(config.DataSourceToncenter, &fakeSource{})
+	// a missing account is an answer, not a failure of the source
+	if _, err := source.AccountState(context.Background(), "addr"); !errors.Is(err, ErrAccountStateNotFound) {
+		t.Fatalf("want ErrAccountStateNotFound, got %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.SourceRequestErrors.WithLabelValues(name, "account_state")); got != errorsBefore {
+		t.Errorf("errors = %v, want %v", got, errorsBefore)
+	}
+
+	source = instrument(config.DataSourceToncenter, &fakeSource{err: errors.New("unavailable")})
+	if _, err := source.AccountState(context.Background(), "addr"); err == nil {
+		t.Fatal("want error")
+	}
+	if got := testutil.ToFloat64(metrics.SourceRequestErrors.WithLabelValues(name, "account_state")); got != errorsBefore+1 {
+		t.Errorf("errors = %v, want %v", got, errorsBefore+1)
+	}
+
+	state := cell.BeginCell().MustStoreUInt(1, 8).EndCell()
+	source = instrument(config.DataSourceToncenter, &fakeSource{state: state})
+	if data, err := source.AccountState(context.Background(), "addr"); err != nil || data != state {
+		t.Fatalf("AccountState = %v, %v", data, err)
+	}
+	if got := testutil.CollectAndCount(metrics.SourceRequestDuration); got < 3 {
+		t.Errorf("want durations of ok, not_found and error outcomes, got %d series", got)
+	}
+}
+
+func TestPageRecord(t *testing.T) {
+	pool := config.Pool{Name: "metrics-test"}
+	op := int64(0x211)
+	page := indexedPage{
+		logs:     []config.OnchainLog{{TxType: "supply"}, {TxType: "supply"}, {TxType: "withdraw"}},
+		unparsed: []config.OnchainUnparsedLog{{OpCode: &op}, {}},
+	}
+	page.record(pool, 2)
+
+	checks := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"transactions", testutil.ToFloat64(metrics.TransactionsIndexed.WithLabelValues(pool.Name)), 2},
+		{"supply logs", testutil.ToFloat64(metrics.LogsIndexed.WithLabelValues(pool.Name, "supply")), 2},
+		{"withdraw logs", testutil.ToFloat64(metrics.LogsIndexed.WithLabelValues(pool.Name, "withdraw")), 1},
+		{"known opcode failures", testutil.ToFloat64(metrics.ParseFailures.WithLabelValues(pool.Name, "0x211")), 1},
+		{"unknown opcode failures", testutil.ToFloat64(metrics.ParseFailures.WithLabelValues(pool.Name, "unknown")), 1},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package indexer
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/liteclient"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// liteserverTxBatch is how many transactions are requested from a liteserver at once
+const liteserverTxBatch = 16
+
+type txRef struct {
+	lt    uint64
+	hash  []byte
+	utime int64
+}
+
+func (r txRef) transaction() ProcessedTransaction {
+	return ProcessedTransaction{Hash: strings.ToUpper(hex.EncodeToString(r.hash)), LT: int64(r.lt), Utime: r.utime}
+}
+
+// LiteserverSource is a ChainSource reading account states and transactions directly from liteservers.
+type LiteserverSource struct {
+	api ton.APIClientWrapped
+
+	// liteservers can only list transactions backwards, the checkpoint of a pool keeps transactions
+	// walked after its cursor a page walk apart, ordered by lt. The next page, or a walk interrupted by
+	// an error, resumes from the checkpoint instead of the head of the chain.
+	mu          sync.Mutex
+	checkpoints map[string][]txRef
+}
+
+// connectLiteservers connects to liteservers from the global config file set in cfg,
+// or from the public mainnet config when it is not set.
+func connectLiteservers(ctx context.Context, cfg config.Config) (*liteclient.ConnectionPool, *liteclient.GlobalConfig, error) {
+	var globalConfig *liteclient.GlobalConfig
+	var err error
+
+	if cfg.LiteserverConfigPath != "" {
+		globalConfig, err = liteclient.GetConfigFromFile(cfg.LiteserverConfigPath)
+	} else {
+		globalConfig, err = liteclient.GetConfigFromUrl(ctx, globalConfigUrl)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load liteservers config: %w", err)
+	}
+
+	client := liteclient.NewConnectionPool()
+	if err := client.AddConnectionsFromConfig(ctx, globalConfig); err != nil {
+		return nil, nil, fmt.Errorf("liteclient connection error: %w", err)
+	}
+
+	return client, globalConfig, nil
+}
+
+func parseProofCheckPolicy(policy string) (ton.ProofCheckPolicy, error) {
+	switch policy {
+	case "", "fast":
+		return ton.ProofCheckPolicyFast, nil
+	case "secure":
+		return ton.ProofCheckPolicySecure, nil
+	case "unsafe":
+		return ton.ProofCheckPolicyUnsafe, nil
+	default:
+		return 0, fmt.Errorf("unknown liteserverProofCheck %q", policy)
+	}
+}
+
+func NewLiteserverSource(ctx context.Context, cfg config.Config) (*LiteserverSource, error) {
+	policy, err := parseProofCheckPolicy(cfg.LiteserverProofCheck)
+	if err != nil {
+		return nil, err
+	}
+
+	client, globalConfig, err := connectLiteservers(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	api := ton.NewAPIClient(client, policy)
+	if policy == ton.ProofCheckPolicySecure {
+		api.SetTrustedBlockFromConfig(globalConfig)
+	}
+
+	return &LiteserverSource{
+		api:         api.WithRetry(),
+		checkpoints: make(map[string][]txRef),
+	}, nil
+}
+
+func (s *LiteserverSource) account(ctx context.Context, addr *address.Address) (*tlb.Account, error) {
+	block, err := s.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error per getting current block: %w", err)
+	}
+
+	return s.api.WaitForBlock(block.SeqNo).GetAccount(ctx, block, addr)
+}
+
+func (s *LiteserverSource) AccountState(ctx context.Context, userContractAddress string) (*cell.Cell, error) {
+	addr, err := address.ParseAddr(userContractAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	acc, err := s.account(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("error per getting account %s: %w", userContractAddress, err)
+	}
+
+	if !acc.IsActive || acc.Data == nil {
+		return nil, fmt.Errorf("%w: %s is not active", ErrAccountStateNotFound, userContractAddress)
+	}
+
+	return acc.Data, nil
+}
+
+func (s *LiteserverSource) PoolTransactions(ctx context.Context, poolAddress string, after TxCursor, limit int) ([]ProcessedTransaction, error) {
+	addr, err := address.ParseAddr(poolAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := s.walkStart(ctx, addr, poolAddress, after)
+	if err != nil || start == nil {
+		return nil, err
+	}
+
+	// transactions are collected from the newest one, only the oldest limit of them are kept
+	var results []ProcessedTransaction
+	lt, hash := start.lt, start.hash
+	every := checkpointBatches(limit)
+
+	for batch := 0; ; batch++ {
+		txs, err := s.api.ListTransactions(ctx, addr, liteserverTxBatch, lt, hash)
+		if errors.Is(err, ton.ErrNoTransactionsWereFound) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error per listing transactions of %s: %w", poolAddress, err)
+		}
+
+		if batch > 0 && batch%every == 0 {
+			newest := txs[len(txs)-1]
+			s.remember(poolAddress, txRef{lt: newest.LT, hash: newest.Hash, utime: int64(newest.Now)})
+		}
+
+		reachedCursor := false
+		for i := len(txs) - 1; i >= 0; i-- {
+			processed, err := processLiteserverTransaction(txs[i])
+			if err != nil {
+				return nil, err
+			}
+			if !after.Precedes(processed) {
+				reachedCursor = true
+				break
+			}
+			if len(processed.OutMsgBodies) == 0 {
+				continue
+			}
+
+			results = append(results, processed)
+			if len(results) > limit {
+				results = results[1:]
+			}
+		}
+
+		oldest := txs[0]
+		if reachedCursor || oldest.PrevTxLT == 0 {
+			break
+		}
+		lt, hash = oldest.PrevTxLT, oldest.PrevTxHash
+	}
+
+	slices.Reverse(results)
+
+	return results, nil
+}
+
+// checkpointBatches is how many transaction batches apart checkpoints are taken, not every
+// transaction emits logs so a page walk covers twice as many transactions as the page size
+func checkpointBatches(limit int) int {
+	return 2 * (limit/liteserverTxBatch + 1)
+}
+
+// walkStart returns the second checkpoint after the cursor, the first one may be too close to it
+// to fill a page, or the last transaction of the account. Checkpoints behind the cursor are dropped.
+func (s *LiteserverSource) walkStart(ctx context.Context, addr *address.Address, poolAddress string, after TxCursor) (*txRef, error) {
+	s.mu.Lock()
+	refs := s.checkpoints[poolAddress]
+	behind := 0
+	for behind < len(refs) && !after.Precedes(refs[behind].transaction()) {
+		behind++
+	}
+	refs = slices.Delete(refs, 0, behind)
+	s.checkpoints[poolAddress] = refs
+
+	var start *txRef
+	if len(refs) > 1 {
+		ref := refs[1]
+		start = &ref
+	}
+	s.mu.Unlock()
+
+	if start != nil {
+		return start, nil
+	}
+
+	acc, err := s.account(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("error per getting account %s: %w", poolAddress, err)
+	}
+	if acc.LastTxLT == 0 {
+		return nil, nil
+	}
+
+	return &txRef{lt: acc.LastTxLT, hash: acc.LastTxHash}, nil
+}
+
+// remember adds the walked transaction to the checkpoint of the pool.
+func (s *LiteserverSource) remember(poolAddress string, ref txRef) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refs := s.checkpoints[poolAddress]
+	i, found := slices.BinarySearchFunc(refs, ref.lt, func(r txRef, lt uint64) int {
+		return cmp.Compare(r.lt, lt)
+	})
+	if !found {
+		s.checkpoints[poolAddress] = slices.Insert(refs, i, ref)
+	}
+}
+
+func processLiteserverTransaction(tx *tlb.Transaction) (ProcessedTransaction, error) {
+	var bodies []string
+	var indexes []int
+
+	if tx.IO.Out != nil {
+		msgs, err := tx.IO.Out.ToSlice()
+		if err != nil {
+			return ProcessedTransaction{}, fmt.Errorf("error per loading out messages of %x: %w", tx.Hash, err)
+		}
+
+		for idx, msg := range msgs {
+			if msg.MsgType != tlb.MsgTypeExternalOut {
+				continue
+			}
+			body := msg.AsExternalOut().Body
+			if body == nil {
+				continue
+			}
+			bodies = append(bodies, base64.StdEncoding.EncodeToString(body.ToBOC()))
+			indexes = append(indexes, idx)
+		}
+	}
+
+	return ProcessedTransaction{
+		Hash:          strings.ToUpper(hex.EncodeToString(tx.Hash)),
+		LT:            int64(tx.LT),
+		Utime:         int64(tx.Now),
+		OutMsgBodies:  bodies,
+		OutMsgIndexes: indexes,
+	}, nil
+}
@@ -0,0 +1,185 @@
+package indexer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// fakeLiteserver serves transactions of a single account, listed backwards like a liteserver does.
+type fakeLiteserver struct {
+	ton.APIClientWrapped
+
+	txs   []*tlb.Transaction
+	calls int
+	// failAfter makes ListTransactions fail once it was called that many times, when set
+	failAfter int
+}
+
+func newFakeLiteserver(t *testing.T, count int, withoutLogs func(lt uint64) bool) *fakeLiteserver {
+	t.Helper()
+
+	f := &fakeLiteserver{}
+	var prev *tlb.Transaction
+	for lt := uint64(1); lt <= uint64(count); lt++ {
+		tx := &tlb.Transaction{LT: lt, Now: uint32(1700000000 + lt), Hash: txHash(lt)}
+		if prev != nil {
+			tx.PrevTxLT, tx.PrevTxHash = prev.LT, prev.Hash
+		}
+		if !withoutLogs(lt) {
+			tx.IO.Out = extOutMessages(t, lt)
+		}
+		f.txs = append(f.txs, tx)
+		prev = tx
+	}
+	return f
+}
+
+func txHash(lt uint64) []byte {
+	hash := make([]byte, 32)
+	binary.BigEndian.PutUint64(hash[24:], lt)
+	return hash
+}
+
+func extOutMessages(t *testing.T, lt uint64) *tlb.MessagesList {
+	t.Helper()
+
+	msg, err := tlb.ToCell(&tlb.ExternalMessageOut{
+		SrcAddr:   address.MustParseAddr("EQC8rUZqR_pWV1BylWUlPNBzyiTYVoBEmQkMIQDZXICfnuRr"),
+		DstAddr:   address.NewAddressNone(),
+		CreatedLT: lt,
+		Body:      cell.BeginCell().MustStoreUInt(lt, 64).EndCell(),
+	})
+	if err != nil {
+		t.Fatalf("cannot build message: %v", err)
+	}
+
+	list := cell.NewDict(15)
+	if err := list.SetIntKey(big.NewInt(0), cell.BeginCell().MustStoreRef(msg).EndCell()); err != nil {
+		t.Fatalf("cannot build messages list: %v", err)
+	}
+	return &tlb.MessagesList{List: list}
+}
+
+func (f *fakeLiteserver) CurrentMasterchainInfo(context.Context) (*ton.BlockIDExt, error) {
+	return &ton.BlockIDExt{SeqNo: 1}, nil
+}
+
+func (f *fakeLiteserver) WaitForBlock(uint32) ton.APIClientWrapped {
+	return f
+}
+
+func (f *fakeLiteserver) GetAccount(context.Context, *ton.BlockIDExt, *address.Address) (*tlb.Account, error) {
+	last := f.txs[len(f.txs)-1]
+	return &tlb.Account{IsActive: true, LastTxLT: last.LT, LastTxHash: last.Hash}, nil
+}
+
+func (f *fakeLiteserver) ListTransactions(_ context.Context, _ *address.Address, num uint32, lt uint64, _ []byte) ([]*tlb.Transaction, error) {
+	f.calls++
+	if f.failAfter > 0 && f.calls > f.failAfter {
+		return nil, errors.New("liteserver is gone")
+	}
+	if lt == 0 || lt > uint64(len(f.txs)) {
+		return nil, ton.ErrNoTransactionsWereFound
+	}
+	from := max(0, int(lt)-int(num))
+	return f.txs[from:lt], nil
+}
+
+func TestLiteserverSourcePaging(t *testing.T) {
+	// every fifth transaction emits no logs
+	fake := newFakeLiteserver(t, 500, func(lt uint64) bool { return lt%5 == 0 })
+	source := &LiteserverSource{api: fake, checkpoints: make(map[string][]txRef)}
+	pool := "EQC8rUZqR_pWV1BylWUlPNBzyiTYVoBEmQkMIQDZXICfnuRr"
+	limit := 20
+
+	var cursor TxCursor
+	var got []int64
+	pages := 0
+	for {
+		page, err := source.PoolTransactions(context.Background(), pool, cursor, limit)
+		if err != nil {
+			t.Fatalf("PoolTransactions: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		if len(page) > limit {
+			t.Fatalf("want at most %d transactions, got %d", limit, len(page))
+		}
+		for _, tx := range page {
+			got = append(got, tx.LT)
+		}
+		for _, ref := range source.checkpoints[pool] {
+			if !cursor.Precedes(ref.transaction()) {
+				t.Fatalf("checkpoint %d behind the cursor %d is kept", ref.lt, cursor.Lt)
+			}
+		}
+
+		last := page[len(page)-1]
+		cursor = TxCursor{Lt: last.LT, Hash: last.Hash, Utime: last.Utime}
+		pages++
+	}
+
+	var want []int64
+	for lt := int64(1); lt <= 500; lt++ {
+		if lt%5 != 0 {
+			want = append(want, lt)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d transactions, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("transaction %d: want lt %d, got %d", i, want[i], got[i])
+		}
+	}
+
+	// the history is walked from the head once, then every page resumes from a checkpoint near the cursor
+	walk := 500/liteserverTxBatch + 1
+	if maxCalls := walk + pages*3*checkpointBatches(limit); fake.calls > maxCalls {
+		t.Errorf("want at most %d liteserver calls for %d pages, got %d", maxCalls, pages, fake.calls)
+	}
+	if len(source.checkpoints[pool]) > 1 {
+		t.Errorf("want checkpoints behind the cursor dropped, got %d", len(source.checkpoints[pool]))
+	}
+}
+
+func TestLiteserverSourceResume(t *testing.T) {
+	fake := newFakeLiteserver(t, 500, func(uint64) bool { return false })
+	source := &LiteserverSource{api: fake, checkpoints: make(map[string][]txRef)}
+	pool := "EQC8rUZqR_pWV1BylWUlPNBzyiTYVoBEmQkMIQDZXICfnuRr"
+	cursor := TxCursor{Lt: 10, Hash: txRef{lt: 10, hash: txHash(10)}.transaction().Hash}
+	limit := 20
+
+	// the first walk from the head is interrupted in the middle of the history
+	fake.failAfter = 20
+	if _, err := source.PoolTransactions(context.Background(), pool, cursor, limit); err == nil {
+		t.Fatal("want the liteserver error")
+	}
+	if len(source.checkpoints[pool]) == 0 {
+		t.Fatal("want the interrupted walk checkpointed")
+	}
+
+	fake.calls, fake.failAfter = 0, 0
+	page, err := source.PoolTransactions(context.Background(), pool, cursor, limit)
+	if err != nil {
+		t.Fatalf("PoolTransactions: %v", err)
+	}
+	if len(page) != limit || page[0].LT != 11 || page[limit-1].LT != 30 {
+		t.Fatalf("want transactions 11..30, got %d starting at %v", len(page), page)
+	}
+
+	fullWalk := 500/liteserverTxBatch + 1
+	if fake.calls >= fullWalk*2/3 {
+		t.Errorf("want the walk resumed from the checkpoint, got %d of %d calls", fake.calls, fullWalk)
+	}
+}
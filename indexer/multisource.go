@@ -0,0 +1,104 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// ErrStateMismatch is returned in quorum mode when two sources return different account states.
+var ErrStateMismatch = errors.New("account state mismatch between sources")
+
+type namedSource struct {
+	name   config.DataSource
+	source ChainSource
+}
+
+// MultiSource is a ChainSource querying an ordered list of sources, the next source is used
+// when the previous one fails. In quorum mode account states are fetched from two sources and
+// returned only when they are equal.
+type MultiSource struct {
+	sources []namedSource
+	quorum  bool
+}
+
+func (m *MultiSource) PoolTransactions(ctx context.Context, poolAddress string, after TxCursor, limit int) ([]ProcessedTransaction, error) {
+	var errs []error
+
+	for _, s := range m.sources {
+		transactions, err := s.source.PoolTransactions(ctx, poolAddress, after, limit)
+		if err == nil {
+			return transactions, nil
+		}
+		slog.Warn("source failed to get transactions", "source", s.name, "address", poolAddress, "lt", after.Lt, "hash", after.Hash, "err", err)
+		errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func (m *MultiSource) AccountState(ctx context.Context, address string) (*cell.Cell, error) {
+	type fetchedState struct {
+		name config.DataSource
+		data *cell.Cell
+	}
+
+	need := 1
+	if m.quorum {
+		need = 2
+	}
+
+	var states []fetchedState
+	var errs []error
+
+	for _, s := range m.sources {
+		data, err := s.source.AccountState(ctx, address)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+			continue
+		}
+
+		states = append(states, fetchedState{name: s.name, data: data})
+		if len(states) == need {
+			break
+		}
+	}
+
+	if len(states) < need {
+		if len(states) > 0 {
+			errs = append(errs, fmt.Errorf("no quorum for %s, got state only from %s", address, states[0].name))
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	if m.quorum && !bytes.Equal(states[0].data.Hash(), states[1].data.Hash()) {
+		discrepancy := config.OnchainStateDiscrepancy{
+			ContractAddress: address,
+			FirstSource:     string(states[0].name),
+			FirstHash:       hex.EncodeToString(states[0].data.Hash()),
+			FirstData:       base64.StdEncoding.EncodeToString(states[0].data.ToBOC()),
+			SecondSource:    string(states[1].name),
+			SecondHash:      hex.EncodeToString(states[1].data.Hash()),
+			SecondData:      base64.StdEncoding.EncodeToString(states[1].data.ToBOC()),
+			CreatedAt:       time.Now(),
+		}
+
+		db, _ := config.GetDBInstance()
+		if err := db.Create(&discrepancy).Error; err != nil {
+			slog.Error("error per saving state discrepancy", "contract", address, "err", err)
+		}
+
+		return nil, fmt.Errorf("%w: %s %s=%s %s=%s", ErrStateMismatch, address,
+			discrepancy.FirstSource, discrepancy.FirstHash, discrepancy.SecondSource, discrepancy.SecondHash)
+	}
+
+	return states[0].data, nil
+}
@@ -0,0 +1,98 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+type fakeSource struct {
+	transactions []ProcessedTransaction
+	state        *cell.Cell
+	err          error
+	calls        int
+}
+
+func (f *fakeSource) PoolTransactions(_ context.Context, _ string, _ TxCursor, _ int) ([]ProcessedTransaction, error) {
+	f.calls++
+	return f.transactions, f.err
+}
+
+func (f *fakeSource) AccountState(_ context.Context, address string) (*cell.Cell, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.state == nil {
+		return nil, fmt.Errorf("%w: %s", ErrAccountStateNotFound, address)
+	}
+	return f.state, nil
+}
+
+func TestMultiSourceFailover(t *testing.T) {
+	state := cell.BeginCell().MustStoreUInt(1, 8).EndCell()
+
+	broken := &fakeSource{err: errors.New("unavailable")}
+	empty := &fakeSource{}
+	working := &fakeSource{state: state, transactions: []ProcessedTransaction{{Hash: "h1"}}}
+
+	multi := &MultiSource{sources: []namedSource{
+		{name: config.DataSourceDton, source: broken},
+		{name: config.DataSourceToncenter, source: empty},
+		{name: config.DataSourceLiteserver, source: working},
+	}}
+
+	data, err := multi.AccountState(context.Background(), "addr")
+	if err != nil {
+		t.Fatalf("AccountState: %v", err)
+	}
+	if data != state {
+		t.Errorf("want state of the last source")
+	}
+
+	// an empty page is a valid answer, only errors fail over
+	transactions, err := multi.PoolTransactions(context.Background(), "pool", TxCursor{}, 10)
+	if err != nil {
+		t.Fatalf("PoolTransactions: %v", err)
+	}
+	if len(transactions) != 0 || working.calls != 1 {
+		t.Errorf("want transactions of the first successful source, got %v", transactions)
+	}
+
+	multi.sources = multi.sources[:2]
+	if _, err := multi.AccountState(context.Background(), "addr"); !errors.Is(err, ErrAccountStateNotFound) {
+		t.Errorf("want ErrAccountStateNotFound, got %v", err)
+	}
+}
+
+func TestMultiSourceQuorum(t *testing.T) {
+	state := cell.BeginCell().MustStoreUInt(1, 8).EndCell()
+	sameState := cell.BeginCell().MustStoreUInt(1, 8).EndCell()
+
+	first := &fakeSource{state: state}
+	second := &fakeSource{state: sameState}
+	unused := &fakeSource{state: state}
+
+	multi := &MultiSource{quorum: true, sources: []namedSource{
+		{name: config.DataSourceDton, source: first},
+		{name: config.DataSourceToncenter, source: second},
+		{name: config.DataSourceLiteserver, source: unused},
+	}}
+
+	if _, err := multi.AccountState(context.Background(), "addr"); err != nil {
+		t.Fatalf("AccountState: %v", err)
+	}
+	if unused.calls != 0 {
+		t.Errorf("third source must not be queried when two states agree")
+	}
+
+	second.err = errors.New("unavailable")
+	unused.state = nil
+	if _, err := multi.AccountState(context.Background(), "addr"); err == nil {
+		t.Errorf("want error without quorum")
+	}
+}
@@ -2,6 +2,7 @@ package indexer
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"math/big"
 
@@ -24,163 +25,294 @@ const (
 	LogOpCodeLiquidateSuccess uint64 = 0x3
 )
 
-func ParseLogMessage(boc string, logVersion int) (config.OnchainLog, error) {
+// Log versions are layouts of the master contract logs.
+const (
+	// LogVersion0 is the first layout, withdraw and liquidate logs have no owner address
+	LogVersion0 = 0
+	// LogVersion1 adds the owner address to withdraw and liquidate logs
+	LogVersion1 = 1
+	// LogVersion2 adds the subaccount id to all logs
+	LogVersion2 = 2
+)
+
+var (
+	ErrUnknownLogType        = errors.New("unknown log type")
+	ErrUnsupportedLogVersion = errors.New("unsupported log version")
+	ErrMalformedLog          = errors.New("malformed log")
+	// ErrLogVersionMismatch is returned for a log whose layout is not the one of its configured version
+	ErrLogVersionMismatch = errors.New("log layout does not match its version")
+)
+
+type logDecoderKey struct {
+	opCode  uint64
+	version int
+}
+
+// logDecoder fills idxLog from the log slice positioned after the opcode
+type logDecoder func(r *logReader, idxLog *config.OnchainLog)
+
+// todo add decoders of withdraw without an attached asset (0x2), rejected operations (0x11, 0x12, 0x13),
+// jetton flows, fee and reserve collection and config upgrades once their layouts are taken from the
+// master contract source and checked against on-chain log bodies
+var logDecoders = map[logDecoderKey]logDecoder{
+	{LogOpCodeSupplySuccess, LogVersion0}:    decodeSupplyLog(false),
+	{LogOpCodeSupplySuccess, LogVersion1}:    decodeSupplyLog(false),
+	{LogOpCodeSupplySuccess, LogVersion2}:    decodeSupplyLog(true),
+	{LogOpCodeWithdrawSuccess, LogVersion0}:  decodeWithdrawLog(false, false),
+	{LogOpCodeWithdrawSuccess, LogVersion1}:  decodeWithdrawLog(true, false),
+	{LogOpCodeWithdrawSuccess, LogVersion2}:  decodeWithdrawLog(true, true),
+	{LogOpCodeLiquidateSuccess, LogVersion0}: decodeLiquidateLog(false, false),
+	{LogOpCodeLiquidateSuccess, LogVersion1}: decodeLiquidateLog(true, false),
+	{LogOpCodeLiquidateSuccess, LogVersion2}: decodeLiquidateLog(true, true),
+}
+
+// DetectLogVersion returns the layout of logs emitted by the pool in the transaction with the given lt,
+// it is the version of the last log version boundary of the pool at the lt.
+func DetectLogVersion(pool config.Pool, lt int64) (int, error) {
+	version, ok := pool.LogVersionAt(lt)
+	if !ok {
+		return 0, fmt.Errorf("%w: no log version of pool %s at lt %d", ErrUnsupportedLogVersion, pool.Name, lt)
+	}
+	return version, nil
+}
+
+// ParseLogMessage decodes a base64 log BOC emitted by the pool in the transaction with the given lt.
+func ParseLogMessage(boc string, pool config.Pool, lt int64) (config.OnchainLog, error) {
+	decoded, err := base64.StdEncoding.DecodeString(boc)
+	if err != nil {
+		return config.OnchainLog{}, fmt.Errorf("%w: base64 decode: %w", ErrMalformedLog, err)
+	}
+
+	logCell, err := cell.FromBOC(decoded)
+	if err != nil {
+		return config.OnchainLog{}, fmt.Errorf("%w: importing boc: %w", ErrMalformedLog, err)
+	}
+
+	version, err := DetectLogVersion(pool, lt)
+	if err != nil {
+		return config.OnchainLog{}, err
+	}
+
+	idxLog, err := DecodeLog(logCell, version)
+	// until the lt a pool started to emit subaccount ids at is configured, a log of version 1 which
+	// has the layout with the subaccount id is decoded as version 2, as before log versions were added
+	if errors.Is(err, ErrLogVersionMismatch) && version == LogVersion1 && !pool.HasLogVersion(LogVersion2) {
+		if subaccountLog, subaccountErr := DecodeLog(logCell, LogVersion2); subaccountErr == nil {
+			return subaccountLog, nil
+		}
+	}
+	return idxLog, err
+}
 
+// LogOpCode returns the opcode of a base64 log BOC.
+func LogOpCode(boc string) (uint64, error) {
 	decoded, err := base64.StdEncoding.DecodeString(boc)
 	if err != nil {
-		return config.OnchainLog{}, fmt.Errorf("error base64 decode boc log: %w", err)
+		return 0, fmt.Errorf("%w: base64 decode: %w", ErrMalformedLog, err)
 	}
 
 	logCell, err := cell.FromBOC(decoded)
-	// fmt.Printf("%d", logce)
 	if err != nil {
-		return config.OnchainLog{}, fmt.Errorf("error importing boc: %w", err)
+		return 0, fmt.Errorf("%w: importing boc: %w", ErrMalformedLog, err)
+	}
+
+	opCode, err := logCell.BeginParse().LoadUInt(8)
+	if err != nil {
+		return 0, fmt.Errorf("%w: loading opcode: %w", ErrMalformedLog, err)
 	}
 
+	return opCode, nil
+}
+
+// DecodeLog decodes the log cell with the decoder registered for its opcode and the version. A log
+// which does not fit the layout of the version is reported with ErrLogVersionMismatch.
+func DecodeLog(logCell *cell.Cell, version int) (config.OnchainLog, error) {
 	slc := logCell.BeginParse()
+
 	opCode, err := slc.LoadUInt(8)
+	if err != nil {
+		return config.OnchainLog{}, fmt.Errorf("%w: loading opcode: %w", ErrMalformedLog, err)
+	}
 
-	var idxLog config.OnchainLog
-	switch opCode {
-	case LogOpCodeSupplySuccess:
-		idxLog = MustParseSupplyMessage(slc, logVersion)
+	decode, ok := logDecoders[logDecoderKey{opCode: opCode, version: version}]
+	if !ok {
+		for key := range logDecoders {
+			if key.opCode == opCode {
+				return config.OnchainLog{}, fmt.Errorf("%w: opcode 0x%x version %d", ErrUnsupportedLogVersion, opCode, version)
+			}
+		}
+		return config.OnchainLog{}, fmt.Errorf("%w: opcode 0x%x", ErrUnknownLogType, opCode)
+	}
+
+	var decodeErr error
+	idxLog := config.OnchainLog{LogVersion: version}
+	decode(&logReader{slc: slc, err: &decodeErr}, &idxLog)
+
+	if decodeErr != nil {
+		return config.OnchainLog{}, fmt.Errorf("%w: %w: opcode 0x%x version %d: %w", ErrMalformedLog, ErrLogVersionMismatch, opCode, version, decodeErr)
+	}
+	if bitsLeft := slc.BitsLeft(); bitsLeft > 0 {
+		return config.OnchainLog{}, fmt.Errorf("%w: %w: opcode 0x%x version %d: %d bits left", ErrMalformedLog, ErrLogVersionMismatch, opCode, version, bitsLeft)
+	}
+
+	return idxLog, nil
+}
+
+func decodeSupplyLog(withSubaccount bool) logDecoder {
+	return func(r *logReader, idxLog *config.OnchainLog) {
 		idxLog.TxType = MessageTypeSupply
+		idxLog.UserAddress = r.addr()
+		idxLog.SenderAddress = r.addr()
+		idxLog.Utime = int64(r.uint(32))
+		if withSubaccount {
+			idxLog.SubaccountID = int16(r.int(16))
+		}
+
+		r.ref().attachedAsset(idxLog)
 
 		if idxLog.AttachedAssetPrincipal.Cmp(big.NewInt(0)) == 1 {
 			idxLog.TxSubType = MessageSubTypeSupply
 		} else {
 			idxLog.TxSubType = MessageSubTypeRepay
 		}
-	case LogOpCodeWithdrawSuccess:
-		idxLog = MustParseWithdrawMessage(slc, logVersion)
+	}
+}
+
+func decodeWithdrawLog(withOwner, withSubaccount bool) logDecoder {
+	return func(r *logReader, idxLog *config.OnchainLog) {
 		idxLog.TxType = MessageTypeWithdraw
+		idxLog.UserAddress = r.addr()
+		idxLog.SenderAddress = r.addr()
+		if withOwner {
+			r.addr()
+		}
+		idxLog.Utime = int64(r.uint(32))
+
+		// the attached asset ref of logs without subaccounts holds no data
+		if withSubaccount {
+			idxLog.SubaccountID = int16(r.int(16))
+			r.ref().attachedAsset(idxLog)
+		} else {
+			r.ref()
+		}
+
+		r.ref().redeemedAsset(idxLog)
 
 		if idxLog.RedeemedAssetPrincipal.Cmp(big.NewInt(0)) != -1 {
 			idxLog.TxSubType = MessageSubTypeWithdraw
 		} else {
 			idxLog.TxSubType = MessageSubTypeBorrow
 		}
-	case LogOpCodeLiquidateSuccess:
-		idxLog = MustParseLiquidateMessage(slc, logVersion)
-		idxLog.TxType = MessageTypeLiquidation
-	default:
-		err = fmt.Errorf("unknown log type")
-		idxLog.TxType = "unknown"
-		return idxLog, err
 	}
+}
 
-	bitsLeft := slc.BitsLeft()
-
-	/*if idxLog.TxType == "withdraw" {
-		fmt.Printf("%s BitsLeft > 0; %d %s %s\n", idxLog.TxType, bitsLeft, slc.String(), idxLog.RedeemedAssetAddress)
-	}*/
+func decodeLiquidateLog(withOwner, withSubaccount bool) logDecoder {
+	return func(r *logReader, idxLog *config.OnchainLog) {
+		idxLog.TxType = MessageTypeLiquidation
+		idxLog.UserAddress = r.addr()
+		idxLog.SenderAddress = r.addr()
+		if withOwner {
+			r.addr()
+		}
+		idxLog.Utime = int64(r.uint(32))
+		if withSubaccount {
+			idxLog.SubaccountID = int16(r.int(16))
+		}
 
-	if bitsLeft > 0 {
-		fmt.Printf("opcode %d", opCode)
-		err = fmt.Errorf("error pasing tx: logversion: %d %s BitsLeft > 0; %d", logVersion, idxLog.TxType, bitsLeft)
+		r.ref().attachedAsset(idxLog)
+		r.ref().redeemedAsset(idxLog)
 	}
-
-	return idxLog, err
 }
 
-func MustParseLiquidateMessage(slc *cell.Slice, logVersion int) config.OnchainLog {
-	var idxLog config.OnchainLog
-
-	idxLog.UserAddress = slc.MustLoadAddr().String()
-	idxLog.SenderAddress = slc.MustLoadAddr().String()
+// logReader loads log fields keeping the first error, DecodeLog checks it once at the end.
+type logReader struct {
+	slc *cell.Slice
+	// err is shared with readers of referenced cells
+	err *error
+}
 
-	if logVersion == 1 {
-		slc.MustLoadAddr()
+func (r *logReader) fail(err error) {
+	if *r.err == nil {
+		*r.err = err
 	}
+}
 
-	idxLog.Utime = int64(slc.MustLoadUInt(32))
-
-	if slc.BitsLeft() == 16 { // todo rm all bitsleft checks, replace with check logVersion after release
-		idxLog.SubaccountID = int16(slc.MustLoadInt(16))
-	} else {
-		idxLog.SubaccountID = 0
+func (r *logReader) addr() string {
+	if *r.err != nil {
+		return ""
 	}
-
-	loanAssetData := slc.MustLoadRef()
-	idxLog.AttachedAssetAddress = config.BigInt{Int: loanAssetData.MustLoadBigUInt(256)}
-	idxLog.AttachedAssetAmount = config.BigInt{Int: loanAssetData.MustLoadBigUInt(64)}
-	idxLog.AttachedAssetPrincipal = config.BigInt{Int: big.NewInt(loanAssetData.MustLoadInt(64))}
-	idxLog.AttachedAssetTotalSupplyPrincipal = config.BigInt{Int: big.NewInt(loanAssetData.MustLoadInt(64))}
-	idxLog.AttachedAssetTotalBorrowPrincipal = config.BigInt{Int: big.NewInt(loanAssetData.MustLoadInt(64))}
-	idxLog.AttachedAssetSRate = config.BigInt{Int: loanAssetData.MustLoadBigUInt(64)}
-	idxLog.AttachedAssetBRate = config.BigInt{Int: loanAssetData.MustLoadBigUInt(64)}
-
-	collateralAssetData := slc.MustLoadRef()
-	idxLog.RedeemedAssetAddress = config.BigInt{Int: collateralAssetData.MustLoadBigUInt(256)}
-	idxLog.RedeemedAssetAmount = config.BigInt{Int: collateralAssetData.MustLoadBigUInt(64)}
-	idxLog.RedeemedAssetPrincipal = config.BigInt{Int: big.NewInt(collateralAssetData.MustLoadInt(64))}
-	idxLog.RedeemedAssetTotalSupplyPrincipal = config.BigInt{Int: big.NewInt(collateralAssetData.MustLoadInt(64))}
-	idxLog.RedeemedAssetTotalBorrowPrincipal = config.BigInt{Int: big.NewInt(collateralAssetData.MustLoadInt(64))}
-	idxLog.RedeemedAssetSRate = config.BigInt{Int: collateralAssetData.MustLoadBigUInt(64)}
-	idxLog.RedeemedAssetBRate = config.BigInt{Int: collateralAssetData.MustLoadBigUInt(64)}
-
-	return idxLog
+	addr, err := r.slc.LoadAddr()
+	if err != nil {
+		r.fail(fmt.Errorf("loading address: %w", err))
+		return ""
+	}
+	return addr.String()
 }
 
-func MustParseWithdrawMessage(slc *cell.Slice, logVersion int) config.OnchainLog {
-	var idxLog config.OnchainLog
-
-	idxLog.UserAddress = slc.MustLoadAddr().String()
-	idxLog.SenderAddress = slc.MustLoadAddr().String()
-
-	if logVersion == 1 {
-		slc.MustLoadAddr()
+func (r *logReader) uint(bits uint) uint64 {
+	if *r.err != nil {
+		return 0
 	}
+	v, err := r.slc.LoadUInt(bits)
+	if err != nil {
+		r.fail(fmt.Errorf("loading uint%d: %w", bits, err))
+	}
+	return v
+}
 
-	idxLog.Utime = int64(slc.MustLoadUInt(32))
-	if slc.BitsLeft() == 16 {  // new version of withdraw log
-		idxLog.SubaccountID = int16(slc.MustLoadInt(16))
-
-		attachedAssetData := slc.MustLoadRef()
-		idxLog.AttachedAssetAddress = config.BigInt{Int: attachedAssetData.MustLoadBigUInt(256)}
-		idxLog.AttachedAssetAmount = config.BigInt{Int: attachedAssetData.MustLoadBigUInt(64)}
-		idxLog.AttachedAssetPrincipal = config.BigInt{Int: big.NewInt(attachedAssetData.MustLoadInt(64))}
-		idxLog.AttachedAssetTotalSupplyPrincipal = config.BigInt{Int: big.NewInt(attachedAssetData.MustLoadInt(64))}
-		idxLog.AttachedAssetTotalBorrowPrincipal = config.BigInt{Int: big.NewInt(attachedAssetData.MustLoadInt(64))}
-		idxLog.AttachedAssetSRate = config.BigInt{Int: attachedAssetData.MustLoadBigUInt(64)}
-		idxLog.AttachedAssetBRate = config.BigInt{Int: attachedAssetData.MustLoadBigUInt(64)}
-	} else {
-		idxLog.SubaccountID = 0
-		slc.MustLoadRef()
+func (r *logReader) int(bits uint) int64 {
+	if *r.err != nil {
+		return 0
 	}
+	v, err := r.slc.LoadInt(bits)
+	if err != nil {
+		r.fail(fmt.Errorf("loading int%d: %w", bits, err))
+	}
+	return v
+}
 
-	redeemedAssetData := slc.MustLoadRef()
-	idxLog.RedeemedAssetAddress = config.BigInt{Int: redeemedAssetData.MustLoadBigUInt(256)}
-	idxLog.RedeemedAssetAmount = config.BigInt{Int: redeemedAssetData.MustLoadBigUInt(64)}
-	idxLog.RedeemedAssetPrincipal = config.BigInt{Int: big.NewInt(redeemedAssetData.MustLoadInt(64))}
-	idxLog.RedeemedAssetTotalSupplyPrincipal = config.BigInt{Int: big.NewInt(redeemedAssetData.MustLoadInt(64))}
-	idxLog.RedeemedAssetTotalBorrowPrincipal = config.BigInt{Int: big.NewInt(redeemedAssetData.MustLoadInt(64))}
-	idxLog.RedeemedAssetSRate = config.BigInt{Int: redeemedAssetData.MustLoadBigUInt(64)}
-	idxLog.RedeemedAssetBRate = config.BigInt{Int: redeemedAssetData.MustLoadBigUInt(64)}
+func (r *logReader) bigUInt(bits uint) config.BigInt {
+	if *r.err != nil {
+		return config.BigInt{Int: big.NewInt(0)}
+	}
+	v, err := r.slc.LoadBigUInt(bits)
+	if err != nil {
+		r.fail(fmt.Errorf("loading uint%d: %w", bits, err))
+		return config.BigInt{Int: big.NewInt(0)}
+	}
+	return config.BigInt{Int: v}
+}
 
-	return idxLog
+func (r *logReader) bigInt(bits uint) config.BigInt {
+	return config.BigInt{Int: big.NewInt(r.int(bits))}
 }
 
-func MustParseSupplyMessage(slc *cell.Slice, logVersion int) config.OnchainLog {
-	var idxLog config.OnchainLog
+// ref returns a reader of the next referenced cell, sharing the error with r.
+func (r *logReader) ref() *logReader {
+	if *r.err != nil {
+		return r
+	}
+	ref, err := r.slc.LoadRef()
+	if err != nil {
+		r.fail(fmt.Errorf("loading ref: %w", err))
+		return r
+	}
+	return &logReader{slc: ref, err: r.err}
+}
 
-	idxLog.UserAddress = slc.MustLoadAddr().String()
-	idxLog.SenderAddress = slc.MustLoadAddr().String()
-	idxLog.Utime = int64(slc.MustLoadUInt(32))
+// assetData loads the asset address, amount, principal, total supply and borrow principals, s and b rates
+func (r *logReader) assetData() (address, amount, principal, totalSupply, totalBorrow, sRate, bRate config.BigInt) {
+	return r.bigUInt(256), r.bigUInt(64), r.bigInt(64), r.bigInt(64), r.bigInt(64), r.bigUInt(64), r.bigUInt(64)
+}
 
-	if slc.BitsLeft() == 16 {
-		idxLog.SubaccountID = int16(slc.MustLoadInt(16))
-	} else {
-		idxLog.SubaccountID = 0
-	}
-	attachedAssetData := slc.MustLoadRef()
-	idxLog.AttachedAssetAddress = config.BigInt{Int: attachedAssetData.MustLoadBigUInt(256)}
-	idxLog.AttachedAssetAmount = config.BigInt{Int: attachedAssetData.MustLoadBigUInt(64)}
-	idxLog.AttachedAssetPrincipal = config.BigInt{Int: big.NewInt(attachedAssetData.MustLoadInt(64))}
-	idxLog.AttachedAssetTotalSupplyPrincipal = config.BigInt{Int: big.NewInt(attachedAssetData.MustLoadInt(64))}
-	idxLog.AttachedAssetTotalBorrowPrincipal = config.BigInt{Int: big.NewInt(attachedAssetData.MustLoadInt(64))}
-	idxLog.AttachedAssetSRate = config.BigInt{Int: attachedAssetData.MustLoadBigUInt(64)}
-	idxLog.AttachedAssetBRate = config.BigInt{Int: attachedAssetData.MustLoadBigUInt(64)}
+func (r *logReader) attachedAsset(idxLog *config.OnchainLog) {
+	idxLog.AttachedAssetAddress, idxLog.AttachedAssetAmount, idxLog.AttachedAssetPrincipal,
+		idxLog.AttachedAssetTotalSupplyPrincipal, idxLog.AttachedAssetTotalBorrowPrincipal,
+		idxLog.AttachedAssetSRate, idxLog.AttachedAssetBRate = r.assetData()
+}
 
-	return idxLog
+func (r *logReader) redeemedAsset(idxLog *config.OnchainLog) {
+	idxLog.RedeemedAssetAddress, idxLog.RedeemedAssetAmount, idxLog.RedeemedAssetPrincipal,
+		idxLog.RedeemedAssetTotalSupplyPrincipal, idxLog.RedeemedAssetTotalBorrowPrincipal,
+		idxLog.RedeemedAssetSRate, idxLog.RedeemedAssetBRate = r.assetData()
 }
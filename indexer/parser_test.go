@@ -0,0 +1,204 @@
+package indexer
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+func assetDataCell(amount uint64, principal int64) *cell.Cell {
+	return cell.BeginCell().
+		MustStoreUInt(1, 256).
+		MustStoreUInt(amount, 64).
+		MustStoreInt(principal, 64).
+		MustStoreInt(1000, 64).
+		MustStoreInt(500, 64).
+		MustStoreUInt(1, 64).
+		MustStoreUInt(1, 64).
+		EndCell()
+}
+
+func withdrawLogCell(user *address.Address, withOwner, withSubaccount bool) *cell.Cell {
+	b := cell.BeginCell().
+		MustStoreUInt(LogOpCodeWithdrawSuccess, 8).
+		MustStoreAddr(user).
+		MustStoreAddr(user)
+	if withOwner {
+		b.MustStoreAddr(user)
+	}
+	b.MustStoreUInt(1700000000, 32)
+	if withSubaccount {
+		b.MustStoreInt(3, 16)
+		b.MustStoreRef(assetDataCell(0, 0))
+	} else {
+		b.MustStoreRef(cell.BeginCell().EndCell())
+	}
+
+	return b.MustStoreRef(assetDataCell(100, -50)).EndCell()
+}
+
+func TestParseLogMessage(t *testing.T) {
+	user := address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
+
+	subaccounts := config.Pool{Name: "test_subaccounts", LogVersions: []config.LogVersionBoundary{{Version: LogVersion2, FromLt: 0}}}
+
+	supply, err := ParseLogMessage(supplyLogBody(user, 1700000000), subaccounts, 1)
+	if err != nil {
+		t.Fatalf("supply: %v", err)
+	}
+	if supply.LogVersion != LogVersion2 || supply.TxType != MessageTypeSupply || supply.TxSubType != MessageSubTypeSupply {
+		t.Errorf("unexpected supply log %+v", supply)
+	}
+	if supply.UserAddress != user.String() || supply.Utime != 1700000000 || supply.AttachedAssetAmount.Int64() != 100 {
+		t.Errorf("unexpected supply log fields %+v", supply)
+	}
+
+	cases := []struct {
+		name           string
+		pool           config.Pool
+		lt             int64
+		withOwner      bool
+		withSubaccount bool
+		wantVersion    int
+	}{
+		{name: "before owner address", pool: config.PoolMain, lt: 1, wantVersion: LogVersion0},
+		{name: "owner address", pool: config.PoolMain, lt: 49828980000001, withOwner: true, wantVersion: LogVersion1},
+		{name: "subaccount", pool: subaccounts, lt: 1, withOwner: true, withSubaccount: true, wantVersion: LogVersion2},
+	}
+	for _, c := range cases {
+		boc := base64.StdEncoding.EncodeToString(withdrawLogCell(user, c.withOwner, c.withSubaccount).ToBOC())
+
+		withdraw, err := ParseLogMessage(boc, c.pool, c.lt)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if withdraw.LogVersion != c.wantVersion || withdraw.TxSubType != MessageSubTypeBorrow || withdraw.RedeemedAssetPrincipal.Int64() != -50 {
+			t.Errorf("%s: unexpected withdraw log %+v", c.name, withdraw)
+		}
+		if c.withSubaccount && withdraw.SubaccountID != 3 {
+			t.Errorf("%s: want subaccount 3, got %d", c.name, withdraw.SubaccountID)
+		}
+	}
+}
+
+func TestParseLogMessageErrors(t *testing.T) {
+	user := address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
+
+	unknown := cell.BeginCell().MustStoreUInt(0x77, 8).EndCell()
+	_, err := DecodeLog(unknown, LogVersion1)
+	if !errors.Is(err, ErrUnknownLogType) {
+		t.Errorf("want ErrUnknownLogType, got %v", err)
+	}
+
+	_, err = DecodeLog(withdrawLogCell(user, true, true), 100)
+	if !errors.Is(err, ErrUnsupportedLogVersion) {
+		t.Errorf("want ErrUnsupportedLogVersion, got %v", err)
+	}
+
+	// a withdraw log without its asset refs must not panic
+	truncated := cell.BeginCell().
+		MustStoreUInt(LogOpCodeWithdrawSuccess, 8).
+		MustStoreAddr(user).
+		MustStoreAddr(user).
+		MustStoreAddr(user).
+		MustStoreUInt(1700000000, 32).
+		EndCell()
+	_, err = DecodeLog(truncated, LogVersion1)
+	if !errors.Is(err, ErrMalformedLog) {
+		t.Errorf("want ErrMalformedLog, got %v", err)
+	}
+
+	// a new layout with more fields is reported instead of being parsed partially
+	_, err = DecodeLog(withdrawLogCell(user, true, true), LogVersion1)
+	if !errors.Is(err, ErrMalformedLog) {
+		t.Errorf("want ErrMalformedLog for trailing data, got %v", err)
+	}
+
+	_, err = ParseLogMessage("not base64", config.PoolMain, 1)
+	if !errors.Is(err, ErrMalformedLog) {
+		t.Errorf("want ErrMalformedLog for invalid boc, got %v", err)
+	}
+
+	// a subaccount log before the version 2 boundary of the pool is not decoded with another version
+	subaccountLog := base64.StdEncoding.EncodeToString(withdrawLogCell(user, true, true).ToBOC())
+	upgraded := config.Pool{Name: "test_upgraded", LogVersions: []config.LogVersionBoundary{
+		{Version: LogVersion1, FromLt: 0},
+		{Version: LogVersion2, FromLt: 1000},
+	}}
+	_, err = ParseLogMessage(subaccountLog, upgraded, 1)
+	if !errors.Is(err, ErrLogVersionMismatch) {
+		t.Errorf("want ErrLogVersionMismatch, got %v", err)
+	}
+
+	_, err = ParseLogMessage(subaccountLog, config.Pool{Name: "test_no_versions"}, 1)
+	if !errors.Is(err, ErrUnsupportedLogVersion) {
+		t.Errorf("want ErrUnsupportedLogVersion for a pool without log versions, got %v", err)
+	}
+}
+
+func TestParseLogMessageOpCodes(t *testing.T) {
+	user := address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
+	subaccounts := config.Pool{Name: "test_subaccounts", LogVersions: []config.LogVersionBoundary{{Version: LogVersion2, FromLt: 0}}}
+
+	// withdraw log with an attached asset, the sub type depends on the redeemed asset only
+	withAttached := cell.BeginCell().
+		MustStoreUInt(LogOpCodeWithdrawSuccess, 8).
+		MustStoreAddr(user).
+		MustStoreAddr(user).
+		MustStoreAddr(user).
+		MustStoreUInt(1700000000, 32).
+		MustStoreInt(0, 16).
+		MustStoreRef(assetDataCell(100, 100)).
+		MustStoreRef(assetDataCell(100, -50)).
+		EndCell()
+
+	idxLog, err := ParseLogMessage(base64.StdEncoding.EncodeToString(withAttached.ToBOC()), subaccounts, 1)
+	if err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+	if idxLog.TxType != MessageTypeWithdraw || idxLog.TxSubType != MessageSubTypeBorrow || idxLog.AttachedAssetAmount.Int64() != 100 {
+		t.Errorf("unexpected withdraw log %s/%s %+v", idxLog.TxType, idxLog.TxSubType, idxLog)
+	}
+
+	// opcodes without a layout verified against the master contract are kept unparsed
+	for _, opCode := range []uint64{0x2, 0x11, 0x12, 0x13} {
+		logCell := cell.BeginCell().MustStoreUInt(opCode, 8).MustStoreAddr(user).EndCell()
+		if _, err := DecodeLog(logCell, LogVersion2); !errors.Is(err, ErrUnknownLogType) {
+			t.Errorf("opcode 0x%x: want ErrUnknownLogType, got %v", opCode, err)
+		}
+	}
+}
+
+func TestParseLogMessageSubaccountFallback(t *testing.T) {
+	user := address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
+	// a recent transaction of the main pool, which has no version 2 boundary configured
+	lt := int64(58000000000001)
+
+	withdraw := base64.StdEncoding.EncodeToString(withdrawLogCell(user, true, true).ToBOC())
+	idxLog, err := ParseLogMessage(withdraw, config.PoolMain, lt)
+	if err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+	if idxLog.LogVersion != LogVersion2 || idxLog.SubaccountID != 3 || idxLog.UserAddress != user.String() {
+		t.Errorf("want subaccount withdraw log, got %+v", idxLog)
+	}
+
+	idxLog, err = ParseLogMessage(supplyLogBody(user, 1700000000), config.PoolMain, lt)
+	if err != nil {
+		t.Fatalf("supply: %v", err)
+	}
+	if idxLog.LogVersion != LogVersion2 || idxLog.TxType != MessageTypeSupply {
+		t.Errorf("want subaccount supply log, got %+v", idxLog)
+	}
+
+	// logs without the subaccount id keep their configured version
+	plain := base64.StdEncoding.EncodeToString(withdrawLogCell(user, true, false).ToBOC())
+	idxLog, err = ParseLogMessage(plain, config.PoolMain, lt)
+	if err != nil || idxLog.LogVersion != LogVersion1 {
+		t.Errorf("want version 1 withdraw log, got %+v %v", idxLog, err)
+	}
+}
@@ -0,0 +1,96 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+
+	sdkConfig "github.com/evaafi/evaa-go-sdk/config"
+	"github.com/evaafi/go-indexer/config"
+	"github.com/xssnick/tonutils-go/address"
+)
+
+// sdkConfigs are the SDK configs pools refer to by sdkConfig
+var sdkConfigs = map[string]func() *sdkConfig.Config{
+	"main-mainnet":   sdkConfig.GetMainMainnetConfig,
+	"lp-mainnet":     sdkConfig.GetLpMainnetConfig,
+	"alts-mainnet":   sdkConfig.GetAltsMainnetConfig,
+	"stable-mainnet": sdkConfig.GetStableMainnetConfig,
+	"main-testnet":   sdkConfig.GetMasterTestnetConfig,
+}
+
+// getSDKPoolConfig returns the SDK config of the pool, nil for an unknown pool
+func getSDKPoolConfig(pool string) *sdkConfig.Config {
+	p, ok := getPoolByName(pool)
+	if !ok {
+		return nil
+	}
+	newConfig, ok := sdkConfigs[p.SDKConfig]
+	if !ok {
+		return nil
+	}
+	return newConfig()
+}
+
+// ValidatePools checks the pools registry before the indexer starts: names are unique, addresses
+// match the master of the SDK config and every pool has log version boundaries of known versions
+// ordered by lt.
+func ValidatePools(pools []config.Pool) error {
+	if len(pools) == 0 {
+		return errors.New("no pools configured")
+	}
+
+	var errs []error
+	names := make(map[string]bool, len(pools))
+	for i, pool := range pools {
+		if pool.Name == "" {
+			errs = append(errs, fmt.Errorf("pool %d: name is empty", i))
+			continue
+		}
+		if names[pool.Name] {
+			errs = append(errs, fmt.Errorf("pool %s: duplicate name", pool.Name))
+		}
+		names[pool.Name] = true
+
+		if err := validatePool(pool); err != nil {
+			errs = append(errs, fmt.Errorf("pool %s: %w", pool.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validatePool(pool config.Pool) error {
+	var errs []error
+
+	master, err := address.ParseAddr(pool.Address)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid address %q: %w", pool.Address, err))
+	}
+
+	newConfig, ok := sdkConfigs[pool.SDKConfig]
+	if !ok {
+		errs = append(errs, fmt.Errorf("unknown sdkConfig %q", pool.SDKConfig))
+	} else if sdkMaster := newConfig().MasterAddress; master != nil && !sdkMaster.Equals(master) {
+		errs = append(errs, fmt.Errorf("sdkConfig %s is of master %s", pool.SDKConfig, sdkMaster.String()))
+	}
+
+	if pool.StartUtime < 0 || pool.StartLt < 0 {
+		errs = append(errs, errors.New("negative start cursor"))
+	}
+
+	if len(pool.LogVersions) == 0 {
+		errs = append(errs, errors.New("no log versions"))
+	} else if first := pool.LogVersions[0].FromLt; first > pool.StartLt {
+		errs = append(errs, fmt.Errorf("first log version boundary %d is after startLt %d", first, pool.StartLt))
+	}
+	for i, boundary := range pool.LogVersions {
+		if boundary.Version < LogVersion0 || boundary.Version > LogVersion2 {
+			errs = append(errs, fmt.Errorf("unknown log version %d", boundary.Version))
+		}
+		if i > 0 && boundary.FromLt <= pool.LogVersions[i-1].FromLt {
+			errs = append(errs, fmt.Errorf("log version %d boundary %d is not after %d",
+				boundary.Version, boundary.FromLt, pool.LogVersions[i-1].FromLt))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,87 @@
+package indexer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/evaafi/go-indexer/config"
+)
+
+func TestValidatePools(t *testing.T) {
+	if err := ValidatePools(config.DefaultPools); err != nil {
+		t.Fatalf("default pools: %v", err)
+	}
+
+	valid := config.PoolMain
+	cases := []struct {
+		name  string
+		pools func() []config.Pool
+		want  string
+	}{
+		{"empty", func() []config.Pool { return nil }, "no pools"},
+		{"no name", func() []config.Pool { p := valid; p.Name = ""; return []config.Pool{p} }, "name is empty"},
+		{"duplicate", func() []config.Pool { return []config.Pool{valid, valid} }, "duplicate name"},
+		{"bad address", func() []config.Pool { p := valid; p.Address = "main"; return []config.Pool{p} }, "invalid address"},
+		{"unknown sdk", func() []config.Pool { p := valid; p.SDKConfig = "main-devnet"; return []config.Pool{p} }, "unknown sdkConfig"},
+		{"sdk of another master", func() []config.Pool { p := valid; p.SDKConfig = "lp-mainnet"; return []config.Pool{p} }, "is of master"},
+		{"no log versions", func() []config.Pool { p := valid; p.LogVersions = nil; return []config.Pool{p} }, "no log versions"},
+		{"versions after start", func() []config.Pool {
+			p := valid
+			p.LogVersions = []config.LogVersionBoundary{{Version: 1, FromLt: 100}}
+			return []config.Pool{p}
+		}, "is after startLt"},
+		{"negative start", func() []config.Pool { p := valid; p.StartUtime = -1; return []config.Pool{p} }, "negative start"},
+		{"unknown version", func() []config.Pool {
+			p := valid
+			p.LogVersions = []config.LogVersionBoundary{{Version: 3, FromLt: 0}}
+			return []config.Pool{p}
+		}, "unknown log version 3"},
+		{"unordered versions", func() []config.Pool {
+			p := valid
+			p.LogVersions = []config.LogVersionBoundary{{Version: 1, FromLt: 100}, {Version: 2, FromLt: 100}}
+			return []config.Pool{p}
+		}, "is not after"},
+	}
+	for _, c := range cases {
+		err := ValidatePools(c.pools())
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: want %q error, got %v", c.name, c.want, err)
+		}
+	}
+}
+
+func TestDetectLogVersion(t *testing.T) {
+	pool := config.Pool{
+		Name: "test_registry",
+		LogVersions: []config.LogVersionBoundary{
+			{Version: LogVersion0, FromLt: 100},
+			{Version: LogVersion1, FromLt: 200},
+			{Version: LogVersion2, FromLt: 300},
+		},
+	}
+
+	cases := []struct {
+		lt      int64
+		want    int
+		wantErr bool
+	}{
+		{lt: 99, wantErr: true},
+		{lt: 100, want: LogVersion0},
+		{lt: 299, want: LogVersion1},
+		{lt: 300, want: LogVersion2},
+		{lt: 1 << 62, want: LogVersion2},
+	}
+	for _, c := range cases {
+		got, err := DetectLogVersion(pool, c.lt)
+		if c.wantErr {
+			if !errors.Is(err, ErrUnsupportedLogVersion) {
+				t.Errorf("lt %d: want ErrUnsupportedLogVersion, got %d %v", c.lt, got, err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("lt %d: want version %d, got %d %v", c.lt, c.want, got, err)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/metrics"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// updateLease is how long a claimed queue item is hidden from other workers
+const updateLease = 2 * time.Minute
+
+// queuePollInterval is how long an idle worker waits before looking for queue items again
+const queuePollInterval = time.Second
+
+// queueStatsInterval is how often the queue size is counted for metrics
+const queueStatsInterval = 15 * time.Second
+
+const (
+	defaultUpdateMaxAttempts   = 10
+	defaultUpdateRetryDelay    = 30 * time.Second
+	defaultUpdateRetryMaxDelay = time.Hour
+)
+
+// retryPolicy defines when failed queue items are retried and when they are moved to dead letters.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+func newRetryPolicy(cfg config.Config) retryPolicy {
+	policy := retryPolicy{
+		maxAttempts: defaultUpdateMaxAttempts,
+		baseDelay:   defaultUpdateRetryDelay,
+		maxDelay:    defaultUpdateRetryMaxDelay,
+	}
+	if cfg.UpdateMaxAttempts > 0 {
+		policy.maxAttempts = cfg.UpdateMaxAttempts
+	}
+	if cfg.UpdateRetryDelay > 0 {
+		policy.baseDelay = time.Duration(cfg.UpdateRetryDelay) * time.Second
+	}
+	if cfg.UpdateRetryMaxDelay > 0 {
+		policy.maxDelay = time.Duration(cfg.UpdateRetryMaxDelay) * time.Second
+	}
+
+	return policy
+}
+
+// delay returns the wait before the next attempt after attempts failures: the base delay doubled
+// for every failure, capped by the max delay, randomized in its upper half so failed items spread out.
+func (p retryPolicy) delay(attempts int) time.Duration {
+	delay := p.maxDelay
+	if shift := attempts - 1; shift < 32 {
+		delay = min(p.baseDelay<<max(shift, 0), p.maxDelay)
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// enqueueUpdates adds user contracts to the update queue. A contract already waiting in the queue
+// is refreshed once, with the latest transaction time and the earliest run time of both entries.
+func enqueueUpdates(db *gorm.DB, items []config.OnchainUpdateQueueItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	table := config.GetTableName(db, &config.OnchainUpdateQueueItem{})
+
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "pool"}, {Name: "contract_address"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"user_address":  gorm.Expr("excluded.user_address"),
+			"subaccount_id": gorm.Expr("excluded.subaccount_id"),
+			"tx_utime":      gorm.Expr(fmt.Sprintf("GREATEST(%s.tx_utime, excluded.tx_utime)", table)),
+			"next_run_at":   gorm.Expr(fmt.Sprintf("LEAST(%s.next_run_at, excluded.next_run_at)", table)),
+			"created_at":    gorm.Expr("excluded.created_at"),
+			"trace_parent":  gorm.Expr("excluded.trace_parent"),
+			// an item updated while it is being processed must not be deleted by its worker
+			"version": gorm.Expr(fmt.Sprintf("%s.version + 1", table)),
+		}),
+	}).CreateInBatches(&items, 1000).Error
+}
+
+// claimUpdate leases the next due queue item, it returns nil when there is nothing to do.
+// Items locked by other workers are skipped, so any number of indexer replicas can share the queue.
+func claimUpdate(db *gorm.DB) (*config.OnchainUpdateQueueItem, error) {
+	var item config.OnchainUpdateQueueItem
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("next_run_at <= ?", time.Now()).
+			Order("next_run_at").
+			Take(&item).Error
+		if err != nil {
+			return err
+		}
+		item.DueAt = item.NextRunAt
+
+		return tx.Model(&item).Update("next_run_at", time.Now().Add(updateLease)).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error per claiming queue item: %w", err)
+	}
+
+	return &item, nil
+}
+
+// completeUpdate removes a processed item from the queue. When the item was enqueued again
+// while it was processed, it is released to be processed once more.
+func completeUpdate(db *gorm.DB, item *config.OnchainUpdateQueueItem) error {
+	result := db.Where("id = ? AND version = ?", item.ID, item.Version).Delete(&config.OnchainUpdateQueueItem{})
+	if result.Error != nil {
+		return fmt.Errorf("error per deleting queue item %d: %w", item.ID, result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	return db.Model(&config.OnchainUpdateQueueItem{}).
+		Where("id = ?", item.ID).
+		Update("next_run_at", time.Now()).Error
+}
+
+// failUpdate schedules the next attempt of a failed item, or moves it to dead letters
+// when it has no attempts left. It reports whether the item became a dead letter. An item
+// enqueued again while it was processed stays in the queue with its attempts reset instead.
+func failUpdate(db *gorm.DB, item *config.OnchainUpdateQueueItem, reason error, policy retryPolicy) (bool, error) {
+	attempts := item.Attempts + 1
+
+	if attempts < policy.maxAttempts {
+		result := db.Model(&config.OnchainUpdateQueueItem{}).
+			Where("id = ? AND version = ?", item.ID, item.Version).
+			Updates(map[string]interface{}{
+				"attempts":    attempts,
+				"next_run_at": time.Now().Add(policy.delay(attempts)),
+				"last_error":  reason.Error(),
+			})
+		if result.Error != nil || result.RowsAffected > 0 {
+			return false, result.Error
+		}
+
+		// the item is due as it was enqueued again, the failure does not delay it
+		err := db.Model(&config.OnchainUpdateQueueItem{}).
+			Where("id = ?", item.ID).
+			Updates(map[string]interface{}{
+				"attempts":   0,
+				"last_error": reason.Error(),
+			}).Error
+
+		return false, err
+	}
+
+	dead := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND version = ?", item.ID, item.Version).Delete(&config.OnchainUpdateQueueItem{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return tx.Model(&config.OnchainUpdateQueueItem{}).
+				Where("id = ?", item.ID).
+				Updates(map[string]interface{}{
+					"attempts":    0,
+					"next_run_at": time.Now(),
+					"last_error":  reason.Error(),
+				}).Error
+		}
+
+		dead = true
+		deadLetter := config.OnchainUpdateDeadLetter{
+			Pool:            item.Pool,
+			ContractAddress: item.ContractAddress,
+			UserAddress:     item.UserAddress,
+			SubaccountID:    item.SubaccountID,
+			TxUtime:         item.TxUtime,
+			Attempts:        attempts,
+			LastError:       reason.Error(),
+			CreatedAt:       item.CreatedAt,
+			FailedAt:        time.Now(),
+		}
+		return tx.Create(&deadLetter).Error
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return dead, nil
+}
+
+// QueueStats is the number of queue items due now and scheduled for later.
+type QueueStats struct {
+	Due       int64
+	Scheduled int64
+}
+
+// GetQueueStats counts the items of the update queue.
+func GetQueueStats(db *gorm.DB) (QueueStats, error) {
+	var stats QueueStats
+	now := time.Now()
+	err := db.Model(&config.OnchainUpdateQueueItem{}).
+		Select("COUNT(*) FILTER (WHERE next_run_at <= ?) AS due, COUNT(*) FILTER (WHERE next_run_at > ?) AS scheduled", now, now).
+		Scan(&stats).Error
+	if err != nil {
+		return stats, fmt.Errorf("error per counting queue items: %w", err)
+	}
+
+	return stats, nil
+}
+
+// recordQueueStats keeps the queue size metrics up to date until the indexer stops.
+func recordQueueStats(ctx context.Context) {
+	db, _ := config.GetDBInstance()
+
+	ticker := time.NewTicker(queueStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		stats, err := GetQueueStats(db)
+		if err != nil {
+			slog.Error("cannot count queue items", "err", err)
+		} else {
+			metrics.UpdateQueueItems.WithLabelValues("due").Set(float64(stats.Due))
+			metrics.UpdateQueueItems.WithLabelValues("scheduled").Set(float64(stats.Scheduled))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-Shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReplayDeadLetters moves dead letters back to the update queue with a fresh retry budget.
+// Empty pool or contract address match any value. It returns the number of replayed items.
+func ReplayDeadLetters(db *gorm.DB, pool, contractAddress string) (int, error) {
+	var replayed int
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&config.OnchainUpdateDeadLetter{})
+		if pool != "" {
+			query = query.Where("pool = ?", pool)
+		}
+		if contractAddress != "" {
+			query = query.Where("contract_address = ?", contractAddress)
+		}
+
+		var deadLetters []config.OnchainUpdateDeadLetter
+		if err := query.Order("id").Find(&deadLetters).Error; err != nil {
+			return err
+		}
+		if len(deadLetters) == 0 {
+			return nil
+		}
+
+		// the same contract can fail several times, it is queued once
+		queued := make(map[[2]string]bool)
+		ids := make([]uint, 0, len(deadLetters))
+		var items []config.OnchainUpdateQueueItem
+		for _, deadLetter := range deadLetters {
+			ids = append(ids, deadLetter.ID)
+
+			key := [2]string{deadLetter.Pool, deadLetter.ContractAddress}
+			if queued[key] {
+				continue
+			}
+			queued[key] = true
+
+			items = append(items, config.OnchainUpdateQueueItem{
+				Pool:            deadLetter.Pool,
+				ContractAddress: deadLetter.ContractAddress,
+				UserAddress:     deadLetter.UserAddress,
+				SubaccountID:    deadLetter.SubaccountID,
+				TxUtime:         deadLetter.TxUtime,
+				NextRunAt:       time.Now(),
+				CreatedAt:       time.Now(),
+			})
+		}
+
+		if err := enqueueUpdates(tx, items); err != nil {
+			return err
+		}
+		// a contract already waiting in the queue gets a fresh retry budget too
+		for _, item := range items {
+			err := tx.Model(&config.OnchainUpdateQueueItem{}).
+				Where("pool = ? AND contract_address = ?", item.Pool, item.ContractAddress).
+				Update("attempts", 0).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		replayed = len(items)
+		return tx.Delete(&config.OnchainUpdateDeadLetter{}, ids).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error per replaying dead letters: %w", err)
+	}
+
+	return replayed, nil
+}
+
+// DumpQueue writes all queue items as a JSON array, it returns the number of written items.
+func DumpQueue(db *gorm.DB, w io.Writer) (int, error) {
+	var items []config.OnchainUpdateQueueItem
+	if err := db.Order("id").Find(&items).Error; err != nil {
+		return 0, fmt.Errorf("error per reading queue items: %w", err)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(items); err != nil {
+		return 0, fmt.Errorf("error encoding JSON: %w", err)
+	}
+
+	return len(items), nil
+}
+
+// LoadQueue adds queue items written by DumpQueue to the queue. Items of contracts already
+// waiting in the queue are merged with them the same way as new updates.
+func LoadQueue(db *gorm.DB, r io.Reader) (int, error) {
+	var items []config.OnchainUpdateQueueItem
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return 0, fmt.Errorf("error decoding JSON: %w", err)
+	}
+
+	for i := range items {
+		// ids and versions belong to the dumped database
+		items[i].ID = 0
+		items[i].Version = 0
+	}
+	if err := enqueueUpdates(db, items); err != nil {
+		return 0, fmt.Errorf("error per loading queue items: %w", err)
+	}
+
+	return len(items), nil
+}
+
+// DrainQueue refreshes the users of all due queue items with cfg.UserSyncWorkers workers and
+// returns when no due item is left. Failed items are rescheduled as usual, so they are not
+// retried by the same drain. It returns the numbers of updated and failed users.
+func DrainQueue(ctx context.Context, cfg config.Config) (int, int, error) {
+	db, err := config.GetDBInstance()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	policy := newRetryPolicy(cfg)
+	var updated, failed atomic.Int64
+	var claimErr error
+	var once sync.Once
+	var wg sync.WaitGroup
+
+	for i := 0; i < max(cfg.UserSyncWorkers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				item, err := claimUpdate(db)
+				if err != nil {
+					once.Do(func() { claimErr = err })
+					return
+				}
+				if item == nil {
+					return
+				}
+
+				if processUpdate(db, item, policy) {
+					updated.Add(1)
+				} else {
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if claimErr == nil {
+		claimErr = ctx.Err()
+	}
+	return int(updated.Load()), int(failed.Load()), claimErr
+}
@@ -0,0 +1,311 @@
+package indexer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+)
+
+func TestUpdateQueue(t *testing.T) {
+	db := testDB(t)
+
+	const pool = "test_queue"
+	cleanup := func() {
+		db.Where("pool = ?", pool).Delete(&config.OnchainUpdateQueueItem{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	now := time.Now()
+	item := config.OnchainUpdateQueueItem{
+		Pool:            pool,
+		ContractAddress: "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa",
+		UserAddress:     "EQD1_i5tUQ-0SrKKRZf588f1CY8E9GDt20eNsH_01acgBiWE",
+		TxUtime:         now.Unix() - 100,
+		NextRunAt:       now.Add(-time.Minute),
+		CreatedAt:       now,
+	}
+	later := item
+	later.TxUtime = now.Unix()
+	later.NextRunAt = now.Add(time.Minute)
+
+	// the same contract is queued once with the latest tx and the earliest run time
+	if err := enqueueUpdates(db, []config.OnchainUpdateQueueItem{item}); err != nil {
+		t.Fatalf("enqueueUpdates: %v", err)
+	}
+	if err := enqueueUpdates(db, []config.OnchainUpdateQueueItem{later}); err != nil {
+		t.Fatalf("enqueueUpdates: %v", err)
+	}
+
+	var queued []config.OnchainUpdateQueueItem
+	db.Where("pool = ?", pool).Find(&queued)
+	if len(queued) != 1 || queued[0].TxUtime != later.TxUtime || !queued[0].NextRunAt.Before(now) {
+		t.Fatalf("unexpected queue %+v", queued)
+	}
+
+	claimed, err := claimUpdate(db)
+	if err != nil || claimed == nil || claimed.Pool != pool {
+		t.Fatalf("want queue item claimed, got %+v %v", claimed, err)
+	}
+
+	// a leased item is not claimed by other workers
+	if again, err := claimUpdate(db); err != nil || (again != nil && again.Pool == pool) {
+		t.Fatalf("leased item claimed again: %+v %v", again, err)
+	}
+
+	policy := retryPolicy{maxAttempts: 2, baseDelay: time.Minute, maxDelay: time.Hour}
+	if dead, err := failUpdate(db, claimed, errors.New("state not found"), policy); err != nil || dead {
+		t.Fatalf("failUpdate: %v %v", dead, err)
+	}
+	var failed config.OnchainUpdateQueueItem
+	db.First(&failed, claimed.ID)
+	if failed.Attempts != 1 || failed.LastError != "state not found" || !failed.NextRunAt.After(now) {
+		t.Errorf("unexpected failed item %+v", failed)
+	}
+
+	// an item enqueued again during processing survives its completion
+	if err := enqueueUpdates(db, []config.OnchainUpdateQueueItem{item}); err != nil {
+		t.Fatalf("enqueueUpdates: %v", err)
+	}
+	if err := completeUpdate(db, claimed); err != nil {
+		t.Fatalf("completeUpdate: %v", err)
+	}
+	var count int64
+	db.Model(&config.OnchainUpdateQueueItem{}).Where("pool = ?", pool).Count(&count)
+	if count != 1 {
+		t.Fatalf("want item enqueued during processing kept, got %d items", count)
+	}
+
+	db.First(&failed, claimed.ID)
+	if err := completeUpdate(db, &failed); err != nil {
+		t.Fatalf("completeUpdate: %v", err)
+	}
+	db.Model(&config.OnchainUpdateQueueItem{}).Where("pool = ?", pool).Count(&count)
+	if count != 0 {
+		t.Errorf("want completed item deleted, got %d items", count)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := retryPolicy{maxAttempts: 10, baseDelay: 10 * time.Second, maxDelay: time.Minute}
+
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: 10 * time.Second},
+		{attempts: 2, want: 20 * time.Second},
+		{attempts: 3, want: 40 * time.Second},
+		{attempts: 4, want: time.Minute},
+		{attempts: 100, want: time.Minute},
+	}
+
+	for _, c := range cases {
+		for i := 0; i < 100; i++ {
+			delay := policy.delay(c.attempts)
+			if delay < c.want/2 || delay > c.want {
+				t.Fatalf("attempt %d: delay %s is out of [%s, %s]", c.attempts, delay, c.want/2, c.want)
+			}
+		}
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	db := testDB(t)
+
+	const pool = "test_dead_letters"
+	cleanup := func() {
+		db.Where("pool = ?", pool).Delete(&config.OnchainUpdateQueueItem{})
+		db.Where("pool = ?", pool).Delete(&config.OnchainUpdateDeadLetter{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	item := config.OnchainUpdateQueueItem{
+		Pool:            pool,
+		ContractAddress: "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa",
+		UserAddress:     "EQD1_i5tUQ-0SrKKRZf588f1CY8E9GDt20eNsH_01acgBiWE",
+		Attempts:        2,
+		NextRunAt:       time.Now(),
+		CreatedAt:       time.Now(),
+	}
+	if err := db.Create(&item).Error; err != nil {
+		t.Fatalf("cannot create queue item: %v", err)
+	}
+
+	policy := retryPolicy{maxAttempts: 3, baseDelay: time.Minute, maxDelay: time.Hour}
+	dead, err := failUpdate(db, &item, errors.New("account is not active"), policy)
+	if err != nil || !dead {
+		t.Fatalf("want item moved to dead letters, got %v %v", dead, err)
+	}
+
+	var deadLetters []config.OnchainUpdateDeadLetter
+	db.Where("pool = ?", pool).Find(&deadLetters)
+	if len(deadLetters) != 1 || deadLetters[0].Attempts != 3 || deadLetters[0].LastError != "account is not active" {
+		t.Fatalf("unexpected dead letters %+v", deadLetters)
+	}
+	var count int64
+	db.Model(&config.OnchainUpdateQueueItem{}).Where("pool = ?", pool).Count(&count)
+	if count != 0 {
+		t.Fatalf("dead letter is still queued")
+	}
+
+	replayed, err := ReplayDeadLetters(db, pool, "")
+	if err != nil || replayed != 1 {
+		t.Fatalf("ReplayDeadLetters: %d %v", replayed, err)
+	}
+
+	var queued config.OnchainUpdateQueueItem
+	if err := db.Where("pool = ?", pool).First(&queued).Error; err != nil {
+		t.Fatalf("replayed item is not queued: %v", err)
+	}
+	if queued.Attempts != 0 || queued.ContractAddress != item.ContractAddress {
+		t.Errorf("unexpected replayed item %+v", queued)
+	}
+	db.Model(&config.OnchainUpdateDeadLetter{}).Where("pool = ?", pool).Count(&count)
+	if count != 0 {
+		t.Errorf("replayed dead letters must be deleted, got %d", count)
+	}
+}
+
+func TestDeadLetterEnqueuedAgain(t *testing.T) {
+	db := testDB(t)
+
+	const pool = "test_dead_letter_enqueued"
+	cleanup := func() {
+		db.Where("pool = ?", pool).Delete(&config.OnchainUpdateQueueItem{})
+		db.Where("pool = ?", pool).Delete(&config.OnchainUpdateDeadLetter{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	item := config.OnchainUpdateQueueItem{
+		Pool:            pool,
+		ContractAddress: "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa",
+		UserAddress:     "EQD1_i5tUQ-0SrKKRZf588f1CY8E9GDt20eNsH_01acgBiWE",
+		Attempts:        2,
+		NextRunAt:       time.Now(),
+		CreatedAt:       time.Now(),
+	}
+	if err := db.Create(&item).Error; err != nil {
+		t.Fatalf("cannot create queue item: %v", err)
+	}
+
+	// a new log of the user is indexed while its last attempt is running
+	fresh := item
+	fresh.ID, fresh.Attempts = 0, 0
+	if err := enqueueUpdates(db, []config.OnchainUpdateQueueItem{fresh}); err != nil {
+		t.Fatalf("enqueueUpdates: %v", err)
+	}
+
+	policy := retryPolicy{maxAttempts: 3, baseDelay: time.Minute, maxDelay: time.Hour}
+	dead, err := failUpdate(db, &item, errors.New("account is not active"), policy)
+	if err != nil || dead {
+		t.Fatalf("want the enqueued item kept, got %v %v", dead, err)
+	}
+
+	var queued config.OnchainUpdateQueueItem
+	if err := db.Where("pool = ?", pool).First(&queued).Error; err != nil {
+		t.Fatalf("enqueued item is lost: %v", err)
+	}
+	if queued.Attempts != 0 || queued.NextRunAt.After(time.Now()) {
+		t.Errorf("want attempts reset and the item due, got %+v", queued)
+	}
+	var count int64
+	db.Model(&config.OnchainUpdateDeadLetter{}).Where("pool = ?", pool).Count(&count)
+	if count != 0 {
+		t.Errorf("want no dead letters, got %d", count)
+	}
+}
+
+func TestDumpLoadQueue(t *testing.T) {
+	db := testDB(t)
+
+	const pool = "test_queue_dump"
+	cleanup := func() {
+		db.Where("pool = ?", pool).Delete(&config.OnchainUpdateQueueItem{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	item := config.OnchainUpdateQueueItem{
+		Pool:            pool,
+		ContractAddress: "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa",
+		UserAddress:     "EQD1_i5tUQ-0SrKKRZf588f1CY8E9GDt20eNsH_01acgBiWE",
+		TxUtime:         1700000000,
+		Attempts:        2,
+		NextRunAt:       time.Now().Add(time.Hour),
+		CreatedAt:       time.Now(),
+	}
+	if err := enqueueUpdates(db, []config.OnchainUpdateQueueItem{item}); err != nil {
+		t.Fatalf("enqueueUpdates: %v", err)
+	}
+
+	var dump bytes.Buffer
+	if _, err := DumpQueue(db.Where("pool = ?", pool), &dump); err != nil {
+		t.Fatalf("DumpQueue: %v", err)
+	}
+	cleanup()
+
+	loaded, err := LoadQueue(db, &dump)
+	if err != nil {
+		t.Fatalf("LoadQueue: %v", err)
+	}
+	if loaded != 1 {
+		t.Fatalf("want 1 loaded item, got %d", loaded)
+	}
+
+	var restored config.OnchainUpdateQueueItem
+	if err := db.Where("pool = ?", pool).Take(&restored).Error; err != nil {
+		t.Fatalf("loaded item not found: %v", err)
+	}
+	if restored.ContractAddress != item.ContractAddress || restored.Attempts != 2 || restored.TxUtime != item.TxUtime {
+		t.Errorf("unexpected loaded item %+v", restored)
+	}
+}
+
+func TestFailUpdateEnqueuedAgain(t *testing.T) {
+	db := testDB(t)
+
+	const pool = "test_fail_enqueued"
+	cleanup := func() {
+		db.Where("pool = ?", pool).Delete(&config.OnchainUpdateQueueItem{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	item := config.OnchainUpdateQueueItem{
+		Pool:            pool,
+		ContractAddress: "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa",
+		UserAddress:     "EQD1_i5tUQ-0SrKKRZf588f1CY8E9GDt20eNsH_01acgBiWE",
+		Attempts:        1,
+		NextRunAt:       time.Now(),
+		CreatedAt:       time.Now(),
+	}
+	if err := db.Create(&item).Error; err != nil {
+		t.Fatalf("cannot create queue item: %v", err)
+	}
+
+	// a new log of the user is indexed while the attempt is running
+	fresh := item
+	fresh.ID, fresh.Attempts = 0, 0
+	if err := enqueueUpdates(db, []config.OnchainUpdateQueueItem{fresh}); err != nil {
+		t.Fatalf("enqueueUpdates: %v", err)
+	}
+
+	policy := retryPolicy{maxAttempts: 5, baseDelay: time.Hour, maxDelay: time.Hour}
+	if _, err := failUpdate(db, &item, errors.New("account is not active"), policy); err != nil {
+		t.Fatalf("failUpdate: %v", err)
+	}
+
+	var queued config.OnchainUpdateQueueItem
+	if err := db.Where("pool = ?", pool).First(&queued).Error; err != nil {
+		t.Fatalf("enqueued item is lost: %v", err)
+	}
+	if queued.Attempts != 0 || queued.NextRunAt.After(time.Now()) {
+		t.Errorf("want attempts reset and the item not delayed by the backoff, got %+v", queued)
+	}
+}
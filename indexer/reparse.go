@@ -0,0 +1,207 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/migrations"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// reparseBatchSize is how many stored logs are read and written at once
+const reparseBatchSize = 1000
+
+// ReparseFilter selects stored logs to decode again, zero values match any log.
+type ReparseFilter struct {
+	Pool   string
+	OpCode *uint64
+	// FromUtime is inclusive, ToUtime is exclusive
+	FromUtime int64
+	ToUtime   int64
+}
+
+// ReparseResult counts logs handled by Reparse.
+type ReparseResult struct {
+	// Reparsed logs were decoded again and updated in onchain_logs
+	Reparsed int
+	// Recovered logs were moved from onchain_unparsed_logs to onchain_logs
+	Recovered int
+	// Failed logs still cannot be decoded
+	Failed int
+}
+
+func (f ReparseFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.Pool != "" {
+		query = query.Where("pool = ?", f.Pool)
+	}
+	if f.FromUtime > 0 {
+		query = query.Where("utime >= ?", f.FromUtime)
+	}
+	if f.ToUtime > 0 {
+		query = query.Where("utime < ?", f.ToUtime)
+	}
+	return query
+}
+
+func (f ReparseFilter) matchOpCode(body string) bool {
+	if f.OpCode == nil {
+		return true
+	}
+	opCode, err := LogOpCode(body)
+	return err == nil && opCode == *f.OpCode
+}
+
+// Reparse decodes stored log bodies with the current decoders, without requesting any data source.
+// Stored logs are updated in place, unparsed logs which can be decoded now are moved to onchain_logs.
+// Logs indexed before bodies were stored are skipped.
+func Reparse(db *gorm.DB, filter ReparseFilter) (ReparseResult, error) {
+	var result ReparseResult
+
+	if err := reparseLogs(db, filter, &result); err != nil {
+		return result, err
+	}
+	if err := reparseUnparsedLogs(db, filter, &result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func reparseLogs(db *gorm.DB, filter ReparseFilter, result *ReparseResult) error {
+	var lastPool, lastHash string
+	lastMsgIndex := -1
+
+	for {
+		var stored []config.OnchainLog
+		query := filter.apply(db.Where("body IS NOT NULL")).
+			Where("(pool, hash, msg_index) > (?, ?, ?)", lastPool, lastHash, lastMsgIndex).
+			Order("pool, hash, msg_index").
+			Limit(reparseBatchSize)
+		if err := query.Find(&stored).Error; err != nil {
+			return fmt.Errorf("error per reading logs: %w", err)
+		}
+		if len(stored) == 0 {
+			return nil
+		}
+
+		last := stored[len(stored)-1]
+		lastPool, lastHash, lastMsgIndex = last.Pool, last.Hash, last.MsgIndex
+
+		var reparsed, moved []config.OnchainLog
+		for _, log := range stored {
+			if !filter.matchOpCode(*log.Body) {
+				continue
+			}
+
+			idxLog, err := parseStoredLog(*log.Body, log.Pool, log.Lt)
+			if err != nil {
+				slog.Warn("cannot reparse log", "pool", log.Pool, "hash", log.Hash, "lt", log.Lt, "err", err)
+				result.Failed++
+				continue
+			}
+
+			idxLog = withLogIdentity(idxLog, log.Pool, log.Hash, log.Lt, log.MsgIndex, *log.Body)
+			// utime is a part of the primary key, a log decoded with another utime replaces the stored row
+			if idxLog.Utime != log.Utime {
+				if err := migrations.EnsureLogPartitions(db, idxLog.Utime, idxLog.Utime); err != nil {
+					return err
+				}
+				moved = append(moved, log)
+			}
+			reparsed = append(reparsed, idxLog)
+		}
+
+		if len(reparsed) > 0 {
+			err := db.Transaction(func(tx *gorm.DB) error {
+				for _, log := range moved {
+					err := tx.Where("pool = ? AND hash = ? AND msg_index = ? AND utime = ?", log.Pool, log.Hash, log.MsgIndex, log.Utime).
+						Delete(&config.OnchainLog{}).Error
+					if err != nil {
+						return err
+					}
+				}
+				return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&reparsed).Error
+			})
+			if err != nil {
+				return fmt.Errorf("error per updating reparsed logs: %w", err)
+			}
+			result.Reparsed += len(reparsed)
+		}
+	}
+}
+
+func reparseUnparsedLogs(db *gorm.DB, filter ReparseFilter, result *ReparseResult) error {
+	var lastPool, lastHash string
+	lastMsgIndex := -1
+
+	for {
+		var unparsed []config.OnchainUnparsedLog
+		query := filter.apply(db.Model(&config.OnchainUnparsedLog{}))
+		if filter.OpCode != nil {
+			query = query.Where("op_code = ?", *filter.OpCode)
+		}
+		query = query.Where("(pool, hash, msg_index) > (?, ?, ?)", lastPool, lastHash, lastMsgIndex).
+			Order("pool, hash, msg_index").
+			Limit(reparseBatchSize)
+		if err := query.Find(&unparsed).Error; err != nil {
+			return fmt.Errorf("error per reading unparsed logs: %w", err)
+		}
+		if len(unparsed) == 0 {
+			return nil
+		}
+
+		last := unparsed[len(unparsed)-1]
+		lastPool, lastHash, lastMsgIndex = last.Pool, last.Hash, last.MsgIndex
+
+		for _, u := range unparsed {
+			idxLog, err := parseStoredLog(u.Body, u.Pool, u.Lt)
+			if err != nil {
+				result.Failed++
+				if errors.Is(err, ErrUnknownLogType) {
+					continue
+				}
+				slog.Warn("cannot reparse log", "pool", u.Pool, "hash", u.Hash, "lt", u.Lt, "err", err)
+				continue
+			}
+
+			idxLog = withLogIdentity(idxLog, u.Pool, u.Hash, u.Lt, u.MsgIndex, u.Body)
+			if err := migrations.EnsureLogPartitions(db, idxLog.Utime, idxLog.Utime); err != nil {
+				return err
+			}
+			err = db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&idxLog).Error; err != nil {
+					return err
+				}
+				return tx.Delete(&u).Error
+			})
+			if err != nil {
+				return fmt.Errorf("error per moving unparsed log %s %s: %w", u.Pool, u.Hash, err)
+			}
+			result.Recovered++
+		}
+	}
+}
+
+// withLogIdentity sets the fields of a decoded log which do not come from its body.
+func withLogIdentity(idxLog config.OnchainLog, pool, hash string, lt int64, msgIndex int, body string) config.OnchainLog {
+	idxLog.Pool = pool
+	idxLog.Hash = hash
+	idxLog.Lt = lt
+	idxLog.MsgIndex = msgIndex
+	idxLog.Body = &body
+	idxLog.CreatedAt = time.Unix(idxLog.Utime, 0)
+	return idxLog
+}
+
+// parseStoredLog decodes a stored log body of the pool from the registry.
+func parseStoredLog(body, poolName string, lt int64) (config.OnchainLog, error) {
+	pool, ok := getPoolByName(poolName)
+	if !ok {
+		return config.OnchainLog{}, fmt.Errorf("unknown pool %s", poolName)
+	}
+	return ParseLogMessage(body, pool, lt)
+}
@@ -0,0 +1,80 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/xssnick/tonutils-go/address"
+)
+
+func TestReparse(t *testing.T) {
+	db := testDB(t)
+
+	pool := testPool(t, "test_reparse").Name
+	cleanup := func() {
+		db.Where("pool = ?", pool).Delete(&config.OnchainLog{})
+		db.Where("pool = ?", pool).Delete(&config.OnchainUnparsedLog{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	user := address.MustParseAddr("EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
+	body := supplyLogBody(user, 1700000000)
+
+	// a log decoded by an older decoder, stored with its body and a wrong utime
+	stale := config.OnchainLog{
+		Hash:        "stale",
+		Pool:        pool,
+		Utime:       1690000000,
+		TxType:      "unknown",
+		Lt:          10,
+		Body:        &body,
+		UserAddress: "old",
+	}
+	if err := db.Create(&stale).Error; err != nil {
+		t.Fatalf("create log: %v", err)
+	}
+
+	opCode := int64(LogOpCodeSupplySuccess)
+	unparsed := config.OnchainUnparsedLog{
+		Hash:     "unparsed",
+		Pool:     pool,
+		MsgIndex: 1,
+		Lt:       20,
+		Utime:    1700000000,
+		OpCode:   &opCode,
+		Body:     body,
+		Error:    "unknown log type",
+	}
+	if err := db.Create(&unparsed).Error; err != nil {
+		t.Fatalf("create unparsed log: %v", err)
+	}
+
+	result, err := Reparse(db, ReparseFilter{Pool: pool})
+	if err != nil {
+		t.Fatalf("Reparse: %v", err)
+	}
+	if result.Reparsed != 1 || result.Recovered != 1 || result.Failed != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	var logs []config.OnchainLog
+	db.Where("pool = ?", pool).Order("hash").Find(&logs)
+	if len(logs) != 2 {
+		t.Fatalf("want 2 logs, got %d", len(logs))
+	}
+	for _, log := range logs {
+		if log.TxType != MessageTypeSupply || log.UserAddress != user.String() || log.Body == nil || log.Utime != 1700000000 {
+			t.Errorf("log %s is not reparsed: %+v", log.Hash, log)
+		}
+	}
+	if logs[1].Hash != "unparsed" || logs[1].Lt != 20 || logs[1].MsgIndex != 1 {
+		t.Errorf("recovered log lost its identity: %+v", logs[1])
+	}
+
+	var left int64
+	db.Model(&config.OnchainUnparsedLog{}).Where("pool = ?", pool).Count(&left)
+	if left != 0 {
+		t.Errorf("want recovered unparsed log deleted, %d left", left)
+	}
+}
@@ -0,0 +1,123 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/evaafi/go-indexer/config"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// ErrAccountStateNotFound is returned by a ChainSource when it has no state for the requested account.
+var ErrAccountStateNotFound = errors.New("account state not found")
+
+// TxCursor points at the last processed transaction of a pool. Transactions are ordered by (lt, hash).
+// A cursor without Lt comes from utime based sync state, transactions after Utime are returned then.
+type TxCursor struct {
+	Lt    int64
+	Hash  string
+	Utime int64
+}
+
+// Precedes reports whether the transaction goes after the cursor.
+func (c TxCursor) Precedes(tx ProcessedTransaction) bool {
+	if c.Lt == 0 {
+		return tx.Utime > c.Utime
+	}
+	return tx.LT > c.Lt || tx.LT == c.Lt && tx.Hash > c.Hash
+}
+
+// pageAfter sorts transactions by (lt, hash) and returns up to limit of them going after the cursor.
+func pageAfter(transactions []ProcessedTransaction, cursor TxCursor, limit int) []ProcessedTransaction {
+	var page []ProcessedTransaction
+	for _, tx := range transactions {
+		if cursor.Precedes(tx) {
+			page = append(page, tx)
+		}
+	}
+
+	sort.Slice(page, func(i, j int) bool {
+		if page[i].LT != page[j].LT {
+			return page[i].LT < page[j].LT
+		}
+		return page[i].Hash < page[j].Hash
+	})
+
+	if len(page) > limit {
+		page = page[:limit]
+	}
+	return page
+}
+
+// ChainSource is a backend the indexer reads blockchain data from.
+type ChainSource interface {
+	// PoolTransactions returns up to limit transactions of the pool emitting ext-out messages
+	// going after the cursor, ordered by (lt, hash).
+	PoolTransactions(ctx context.Context, poolAddress string, after TxCursor, limit int) ([]ProcessedTransaction, error)
+	// AccountState returns the data cell of the account.
+	AccountState(ctx context.Context, address string) (*cell.Cell, error)
+}
+
+var chainSource ChainSource
+
+// SetChainSource replaces the backend used by the indexer loop and the user update workers.
+func SetChainSource(source ChainSource) {
+	chainSource = source
+}
+
+// NewChainSource creates the chain source configured in cfg. Sources listed in dataSources are
+// queried in order, otherwise the single dataSource is used, dton by default. Requests to every
+// source are recorded in metrics, and the sources are the ones the readiness check looks at.
+func NewChainSource(cfg config.Config) (ChainSource, error) {
+	names := cfg.DataSources
+	if len(names) == 0 {
+		names = []config.DataSource{cfg.DataSource}
+	}
+
+	if cfg.StateQuorum && len(names) < 2 {
+		return nil, fmt.Errorf("stateQuorum requires at least two dataSources")
+	}
+
+	if len(names) == 1 {
+		source, err := newSingleChainSource(cfg, names[0])
+		if err != nil {
+			return nil, err
+		}
+		instrumented := instrument(names[0], source)
+		trackSourcesHealth(instrumented)
+		return instrumented, nil
+	}
+
+	multi := &MultiSource{quorum: cfg.StateQuorum}
+	var instrumented []*instrumentedSource
+	for _, name := range names {
+		source, err := newSingleChainSource(cfg, name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		s := instrument(name, source)
+		instrumented = append(instrumented, s)
+		multi.sources = append(multi.sources, namedSource{name: name, source: s})
+	}
+	trackSourcesHealth(instrumented...)
+
+	return multi, nil
+}
+
+func newSingleChainSource(cfg config.Config, name config.DataSource) (ChainSource, error) {
+	switch name {
+	case config.DataSourceDton, "":
+		if cfg.GraphQLEndpoint == "" {
+			return nil, fmt.Errorf("graphqlEndpoint is not set")
+		}
+		return NewDtonSource(cfg.GraphQLEndpoint), nil
+	case config.DataSourceToncenter:
+		return NewToncenterSource(cfg.TonCenterEndpoint, cfg.TonCenterAPIKey, cfg.TonCenterRPS, cfg.TonCenterBurst), nil
+	case config.DataSourceLiteserver:
+		return NewLiteserverSource(context.Background(), cfg)
+	default:
+		return nil, fmt.Errorf("unknown data source %q", name)
+	}
+}
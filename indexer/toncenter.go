@@ -0,0 +1,216 @@
+package indexer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xssnick/tonutils-go/tvm/cell"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultToncenterEndpoint = "https://toncenter.com/api/v3"
+	// toncenter allows 1 rps without api key
+	defaultToncenterRPS = 1
+)
+
+type toncenterMessage struct {
+	Destination    *string `json:"destination"`
+	MessageContent *struct {
+		Body string `json:"body"`
+	} `json:"message_content"`
+}
+
+type toncenterTransaction struct {
+	Hash    string             `json:"hash"`
+	LT      string             `json:"lt"`
+	Now     int64              `json:"now"`
+	OutMsgs []toncenterMessage `json:"out_msgs"`
+}
+
+type toncenterTransactionsResponse struct {
+	Transactions []toncenterTransaction `json:"transactions"`
+}
+
+type toncenterAccountResponse struct {
+	Data   string `json:"data"`
+	Status string `json:"status"`
+}
+
+// ToncenterSource is a ChainSource backed by the toncenter v3 API.
+// Requests are limited by a token bucket with TonCenterRPS refill rate and TonCenterBurst size.
+type ToncenterSource struct {
+	endpoint string
+	apiKey   string
+	limiter  *rate.Limiter
+	client   *http.Client
+}
+
+func NewToncenterSource(endpoint, apiKey string, rps float64, burst int) *ToncenterSource {
+	if endpoint == "" {
+		endpoint = defaultToncenterEndpoint
+	}
+	if rps <= 0 {
+		rps = defaultToncenterRPS
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+
+	return &ToncenterSource{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		apiKey:   apiKey,
+		limiter:  rate.NewLimiter(rate.Limit(rps), burst),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *ToncenterSource) PoolTransactions(ctx context.Context, poolAddress string, after TxCursor, limit int) ([]ProcessedTransaction, error) {
+	var results []ProcessedTransaction
+
+	// toncenter cannot filter transactions by ext-out messages, pages are requested
+	// until enough transactions with logs are found or the end of the chain is reached
+	cursor := after
+	for len(results) <= limit {
+		params := url.Values{}
+		params.Set("account", poolAddress)
+		// start_lt and start_utime are inclusive on toncenter side
+		if cursor.Lt > 0 {
+			params.Set("start_lt", strconv.FormatInt(cursor.Lt, 10))
+		} else {
+			params.Set("start_utime", strconv.FormatInt(cursor.Utime+1, 10))
+		}
+		params.Set("limit", strconv.Itoa(limit+1))
+		params.Set("sort", "asc")
+
+		var resp toncenterTransactionsResponse
+		if _, err := s.get(ctx, "/transactions", params, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, tx := range resp.Transactions {
+			processed, err := tx.process()
+			if err != nil {
+				return nil, err
+			}
+			if !cursor.Precedes(processed) {
+				continue
+			}
+			cursor = TxCursor{Lt: processed.LT, Hash: processed.Hash, Utime: processed.Utime}
+
+			if len(processed.OutMsgBodies) > 0 {
+				results = append(results, processed)
+			}
+		}
+
+		if len(resp.Transactions) < limit+1 {
+			break
+		}
+	}
+
+	return pageAfter(results, after, limit), nil
+}
+
+func (s *ToncenterSource) AccountState(ctx context.Context, address string) (*cell.Cell, error) {
+	params := url.Values{}
+	params.Set("address", address)
+
+	var resp toncenterAccountResponse
+	status, err := s.get(ctx, "/account", params, &resp)
+	if status == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrAccountStateNotFound, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Data == "" {
+		return nil, fmt.Errorf("%w: %s status %s", ErrAccountStateNotFound, address, resp.Status)
+	}
+
+	dataBoc, err := base64.StdEncoding.DecodeString(resp.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 state %s: %w", resp.Data, err)
+	}
+
+	return cell.FromBOC(dataBoc)
+}
+
+func (s *ToncenterSource) get(ctx context.Context, method string, params url.Values, result interface{}) (int, error) {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+method+"?"+params.Encode(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("error in req: %w", err)
+	}
+	if s.apiKey != "" {
+		req.Header.Set("X-API-Key", s.apiKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error in sending http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("error in receiving body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("toncenter %s responded %s: %s", method, resp.Status, body)
+	}
+
+	if err := json.Unmarshal(body, result); err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to unmarshal toncenter %s response: %w", method, err)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (tx toncenterTransaction) process() (ProcessedTransaction, error) {
+	lt, err := strconv.ParseInt(tx.LT, 10, 64)
+	if err != nil {
+		return ProcessedTransaction{}, fmt.Errorf("error converting lt %q: %w", tx.LT, err)
+	}
+
+	// toncenter returns base64 hashes, dton ones are hex
+	hash, err := base64.StdEncoding.DecodeString(tx.Hash)
+	if err != nil {
+		return ProcessedTransaction{}, fmt.Errorf("error decoding tx hash %q: %w", tx.Hash, err)
+	}
+
+	var bodies []string
+	var indexes []int
+	for idx, msg := range tx.OutMsgs {
+		// ext-out messages have no destination
+		if msg.Destination != nil && *msg.Destination != "" {
+			continue
+		}
+		if msg.MessageContent == nil || msg.MessageContent.Body == "" {
+			continue
+		}
+		bodies = append(bodies, msg.MessageContent.Body)
+		indexes = append(indexes, idx)
+	}
+
+	return ProcessedTransaction{
+		Hash:          strings.ToUpper(hex.EncodeToString(hash)),
+		LT:            lt,
+		Utime:         tx.Now,
+		OutMsgBodies:  bodies,
+		OutMsgIndexes: indexes,
+	}, nil
+}
@@ -0,0 +1,93 @@
+package indexer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evaafi/go-indexer/indexer"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+func TestToncenterSource(t *testing.T) {
+	stateCell := cell.BeginCell().MustStoreUInt(7, 8).EndCell()
+	stateBoc := base64.StdEncoding.EncodeToString(stateCell.ToBOC())
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-API-Key") != "key" {
+			t.Errorf("api key is not passed")
+		}
+
+		switch r.URL.Path {
+		case "/transactions":
+			if r.URL.Query().Get("sort") != "asc" {
+				t.Errorf("unexpected query %s", r.URL.RawQuery)
+			}
+			// start_lt is inclusive, the next page starts from the last seen transaction
+			if r.URL.Query().Get("start_lt") == "101" {
+				fmt.Fprint(w, `{"transactions":[
+				{"hash":"AwQ=","lt":"101","now":1700000001,"out_msgs":[]}]}`)
+				return
+			}
+			if r.URL.Query().Get("start_utime") != "1690000001" {
+				t.Errorf("unexpected query %s", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `{"transactions":[
+				{"hash":"AQI=","lt":"100","now":1700000000,"out_msgs":[
+					{"destination":"0:0000","message_content":{"body":"internal"}},
+					{"destination":null,"message_content":{"body":"log"}}]},
+				{"hash":"AwQ=","lt":"101","now":1700000001,"out_msgs":[
+					{"destination":"0:0000","message_content":{"body":"internal"}}]}]}`)
+		case "/account":
+			if r.URL.Query().Get("address") != "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa" {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"error":"not found"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"status":"active","data":"%s"}`, stateBoc)
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	source := indexer.NewToncenterSource(server.URL, "key", 100, 10)
+
+	transactions, err := source.PoolTransactions(context.Background(), "EQC8rUZqR_pWV1BylWUlPNBzyiTYVoBEmQkMIQDZXICfnuRr", indexer.TxCursor{Utime: 1690000000}, 1)
+	if err != nil {
+		t.Fatalf("PoolTransactions: %v", err)
+	}
+	if len(transactions) != 1 {
+		t.Fatalf("want only transactions with ext-out messages, got %d", len(transactions))
+	}
+	tx := transactions[0]
+	if tx.Hash != "0102" || tx.LT != 100 || tx.Utime != 1700000000 {
+		t.Errorf("unexpected transaction %+v", tx)
+	}
+	if len(tx.OutMsgBodies) != 1 || tx.OutMsgBodies[0] != "log" {
+		t.Errorf("want only ext-out bodies, got %v", tx.OutMsgBodies)
+	}
+	if requests != 2 {
+		t.Errorf("want 2 pages requested, got %d", requests)
+	}
+
+	state, err := source.AccountState(context.Background(), "EQDNSnDXSrvfZyEVQ6vaAYHKakqyKE2zbCKQc2JNY-AhbGpa")
+	if err != nil {
+		t.Fatalf("AccountState: %v", err)
+	}
+	if !bytes.Equal(state.Hash(), stateCell.Hash()) {
+		t.Errorf("unexpected state cell %s", state.Dump())
+	}
+
+	_, err = source.AccountState(context.Background(), "EQD1_i5tUQ-0SrKKRZf588f1CY8E9GDt20eNsH_01acgBiWE")
+	if !errors.Is(err, indexer.ErrAccountStateNotFound) {
+		t.Errorf("want ErrAccountStateNotFound, got %v", err)
+	}
+}
@@ -1,8 +1,9 @@
 package indexer
-/*
+
 import (
 	"context"
-	"log"
+	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/evaafi/evaa-go-sdk/asset"
@@ -13,88 +14,157 @@ import (
 	"github.com/xssnick/tonutils-go/ton"
 )
 
+const (
+	globalConfigUrl           = "https://ton-blockchain.github.io/global.config.json"
+	poolsConfigUpdateInterval = 5 * time.Minute
+	pricesUpdateInterval      = 1 * time.Minute
+)
+
 var (
-	PoolParsers = make(map[string]*asset.Parser)
-	PoolPrices  = make(map[string]*price.Prices)
-	poolConfigs = []struct {
-		Name   string
-		Config *sdkConfig.Config
-	}{
-		{Name: config.PoolMain.Name, Config: sdkConfig.GetMainMainnetConfig()},
-			{Name: config.PoolLp.Name, Config: sdkConfig.GetLpMainnetConfig()},
-			{Name: config.PoolAlts.Name, Config: sdkConfig.GetAltsMainnetConfig()},
-	}
+	poolDataMu  sync.RWMutex
+	poolParsers = make(map[string]*asset.Parser)
+	poolPrices  = make(map[string]*price.Prices)
 )
 
-func init() {
-	go RunUpdatePoolsConfigPeriodically()
-	go StartUpdatePrices()
+type poolConfig struct {
+	Name   string
+	Config *sdkConfig.Config
 }
 
-func StartUpdatePrices() {
-	go func() {
-		ctx := context.Background()
-		ticker := time.NewTicker(1 * time.Minute)
-		defer ticker.Stop()
-
-		for {
-			for _, config := range poolConfigs {
-				svc := price.NewService(config.Config, nil)
-
-				p, err := svc.GetPrices(ctx, "https://api.evaa.space/api/prices")
-				if err != nil {
-					log.Printf("price update error: %v", err)
-				} else {
-					PoolPrices[config.Name] = p
-				}
-			}
-			<-ticker.C
+// poolConfigs returns the SDK configs of the pools of the registry
+func poolConfigs() []poolConfig {
+	configs := make([]poolConfig, 0, len(config.Pools))
+	for _, pool := range config.Pools {
+		if sdkCfg := getSDKPoolConfig(pool.Name); sdkCfg != nil {
+			configs = append(configs, poolConfig{Name: pool.Name, Config: sdkCfg})
 		}
-	}()
+	}
+	return configs
+}
+
+// GetPoolData returns the latest asset parser and prices of the pool together with its sdk config.
+// ok is false until both assets and prices have been loaded at least once.
+func GetPoolData(name string) (parser *asset.Parser, prices *price.Prices, sdkCfg *sdkConfig.Config, ok bool) {
+	poolDataMu.RLock()
+	defer poolDataMu.RUnlock()
+
+	sdkCfg = getSDKPoolConfig(name)
+	parser, okParser := poolParsers[name]
+	prices, okPrices := poolPrices[name]
+	return parser, prices, sdkCfg, okParser && okPrices && sdkCfg != nil
+}
+
+// RunPoolUpdater keeps assets data/config and oracle prices of every pool up to date until ctx is done.
+func RunPoolUpdater(ctx context.Context, cfg config.Config) {
+	go runUpdatePricesPeriodically(ctx, cfg)
+	go runUpdatePoolsConfigPeriodically(ctx, cfg)
 }
 
-func RunUpdatePoolsConfigPeriodically() {
-	ticker := time.NewTicker(5 * time.Minute)
+func runUpdatePricesPeriodically(ctx context.Context, cfg config.Config) {
+	ticker := time.NewTicker(pricesUpdateInterval)
 	defer ticker.Stop()
 
-	updatePoolsConfig()
+	for {
+		updatePrices(ctx, cfg.PriceEndpoints)
 
-	for range ticker.C {
-		updatePoolsConfig()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
-func updatePoolsConfig() {
-	client := liteclient.NewConnectionPool()
-	// connect to mainnet lite servers
-	err := client.AddConnectionsFromConfigUrl(context.Background(), "https://ton-blockchain.github.io/global.config.json")
+func updatePrices(ctx context.Context, endpoints []string) {
+	for _, pc := range poolConfigs() {
+		svc := price.NewService(pc.Config, nil)
+
+		p, err := svc.GetPrices(ctx, endpoints...)
+		if err != nil {
+			slog.Warn("price update error", "pool", pc.Name, "err", err)
+			continue
+		}
+
+		poolDataMu.Lock()
+		poolPrices[pc.Name] = p
+		poolDataMu.Unlock()
+	}
+}
+
+func runUpdatePoolsConfigPeriodically(ctx context.Context, cfg config.Config) {
+	ticker := time.NewTicker(poolsConfigUpdateInterval)
+	defer ticker.Stop()
+
+	var client *liteclient.ConnectionPool
+	for {
+		var err error
+		client, _, err = connectLiteservers(ctx, cfg)
+		if err == nil {
+			break
+		}
+		slog.Error("cannot connect to liteservers", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+	defer client.Stop()
+
+	api := ton.NewAPIClient(client, ton.ProofCheckPolicyFast).WithRetry()
+
+	for {
+		updatePoolsConfig(ctx, api)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func updatePoolsConfig(ctx context.Context, api ton.APIClientWrapped) {
+	block, err := api.CurrentMasterchainInfo(ctx)
 	if err != nil {
-		log.Fatalln("connection err: ", err.Error())
+		slog.Warn("error per getting current block", "err", err)
 		return
 	}
 
-	for _, config := range poolConfigs {
-		log.Printf("updating assets of %s pool", config.Name)
-		api := ton.NewAPIClient(client, ton.ProofCheckPolicyFast).WithRetry()
-		parser := asset.NewParser(config.Config)
+	for _, pc := range poolConfigs() {
+		parser := asset.NewParser(pc.Config)
+		addr := pc.Config.MasterAddress
 
-		addr := config.Config.MasterAddress
-
-		block, err := api.CurrentMasterchainInfo(context.Background())
+		assetsData, err := api.WaitForBlock(block.SeqNo).RunGetMethod(ctx, block, addr, "getAssetsData")
 		if err != nil {
-			log.Println("error per getting current block")
+			slog.Warn("error per getting getAssetsData", "pool", pc.Name, "err", err)
+			continue
 		}
-		assetsData, err := api.WaitForBlock(block.SeqNo).RunGetMethod(context.Background(), block, addr, "getAssetsData")
+		assetsConfig, err := api.RunGetMethod(ctx, block, addr, "getAssetsConfig")
 		if err != nil {
-			log.Println("error per getting getAssetsData")
+			slog.Warn("error per getting getAssetsConfig", "pool", pc.Name, "err", err)
+			continue
 		}
-		assetsConfig, err := api.RunGetMethod(context.Background(), block, addr, "getAssetsConfig")
+
+		dataCell, err := assetsData.Cell(0)
 		if err != nil {
-			log.Println("error per getting assetsConfig")
+			slog.Warn("unexpected getAssetsData result", "pool", pc.Name, "err", err)
+			continue
+		}
+		configCell, err := assetsConfig.Cell(0)
+		if err != nil {
+			slog.Warn("unexpected getAssetsConfig result", "pool", pc.Name, "err", err)
+			continue
+		}
+
+		if err := parser.SetInfo(dataCell.AsDict(256), configCell.AsDict(256)); err != nil {
+			slog.Warn("error per parsing assets", "pool", pc.Name, "err", err)
+			continue
 		}
 
-		parser.SetInfo(assetsData.MustCell(0).AsDict(256), assetsConfig.MustCell(0).AsDict(256))
-		PoolParsers[config.Name] = parser
+		poolDataMu.Lock()
+		poolParsers[pc.Name] = parser
+		poolDataMu.Unlock()
 	}
 }
-*/
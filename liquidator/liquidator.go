@@ -0,0 +1,227 @@
+package liquidator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/evaafi/evaa-go-sdk/asset"
+	sdkConfig "github.com/evaafi/evaa-go-sdk/config"
+	"github.com/evaafi/evaa-go-sdk/price"
+	sdkPrincipal "github.com/evaafi/evaa-go-sdk/principal"
+	"github.com/evaafi/go-indexer/config"
+	"github.com/evaafi/go-indexer/indexer"
+	"github.com/xssnick/tonutils-go/address"
+	"gorm.io/gorm"
+)
+
+const defaultInterval = 10 * time.Second
+
+// Candidates receives the latest ranked list of liquidatable positions after every round.
+// Only the newest list is kept, a slow reader never blocks the liquidator.
+var Candidates = make(chan []config.OnchainLiquidationCandidate, 1)
+
+type poolSnapshot struct {
+	assets  *asset.Parser
+	prices  *price.Prices
+	sdkCfg  *sdkConfig.Config
+	service *sdkPrincipal.Service
+}
+
+// Run recalculates health of every indexed user position and publishes liquidation candidates until ctx is done.
+func Run(ctx context.Context, cfg config.Config) {
+	interval := defaultInterval
+	if cfg.LiquidatorInterval > 0 {
+		interval = time.Duration(cfg.LiquidatorInterval) * time.Second
+	}
+
+	indexer.RunPoolUpdater(ctx, cfg)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		candidates, err := findCandidates()
+		if err != nil {
+			slog.Error("error per finding liquidation candidates", "err", err)
+			continue
+		}
+
+		if err := publish(candidates); err != nil {
+			slog.Error("error per publishing liquidation candidates", "candidates", len(candidates), "err", err)
+		}
+	}
+}
+
+func findCandidates() ([]config.OnchainLiquidationCandidate, error) {
+	db, _ := config.GetDBInstance()
+
+	snapshots := make(map[string]*poolSnapshot)
+	for _, pool := range config.Pools {
+		parser, prices, sdkCfg, ok := indexer.GetPoolData(pool.Name)
+		if !ok {
+			continue
+		}
+		snapshots[pool.Name] = &poolSnapshot{
+			assets:  parser.UpdateCurrentRates(0),
+			prices:  prices,
+			sdkCfg:  sdkCfg,
+			service: sdkPrincipal.NewService(sdkCfg),
+		}
+	}
+
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("pools data is not loaded yet")
+	}
+
+	var candidates []config.OnchainLiquidationCandidate
+	// OnchainUser has a composite primary key, so users are paged by the unique contract address
+	// instead of FindInBatches which requires a single primary key
+	var lastContract string
+	for {
+		var users []config.OnchainUser
+		err := db.Where("contract_address > ?", lastContract).
+			Order("contract_address").
+			Limit(1000).
+			Find(&users).Error
+		if err != nil {
+			return nil, fmt.Errorf("error per loading users: %w", err)
+		}
+		if len(users) == 0 {
+			break
+		}
+		lastContract = users[len(users)-1].ContractAddress
+
+		for _, u := range users {
+			snapshot, ok := snapshots[u.Pool]
+			if !ok {
+				continue
+			}
+			if candidate, ok := checkUser(u, snapshot); ok {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+
+	rankCandidates(candidates)
+
+	return candidates, nil
+}
+
+func checkUser(u config.OnchainUser, snapshot *poolSnapshot) (config.OnchainLiquidationCandidate, bool) {
+	principals := make(map[string]*big.Int, len(u.Principals))
+	for id, value := range u.Principals {
+		if id.Int == nil || value.Int == nil || value.Sign() == 0 {
+			continue
+		}
+		principals[id.String()] = value.Int
+	}
+	if len(principals) == 0 {
+		return config.OnchainLiquidationCandidate{}, false
+	}
+
+	contractAddress, err := address.ParseAddr(u.ContractAddress)
+	if err != nil {
+		return config.OnchainLiquidationCandidate{}, false
+	}
+	user := sdkPrincipal.NewUserSC(contractAddress).SetPrincipals(principals)
+
+	health := snapshot.service.CalculateHealth(user, snapshot.assets, snapshot.prices)
+	if !health.IsLiquidatable() {
+		return config.OnchainLiquidationCandidate{}, false
+	}
+
+	candidate := config.OnchainLiquidationCandidate{
+		WalletAddress:     u.WalletAddress,
+		Pool:              u.Pool,
+		SubaccountID:      u.SubaccountID,
+		ContractAddress:   u.ContractAddress,
+		HealthFactor:      healthFactor(health),
+		TotalSupply:       config.BigInt{Int: health.TotalSupply},
+		TotalDebt:         config.BigInt{Int: health.TotalDebt},
+		TotalLimit:        config.BigInt{Int: health.TotalLimit},
+		LoanAsset:         config.BigInt{Int: health.GreatestLoanAsset},
+		LoanValue:         config.BigInt{Int: health.GreatestLoanValue},
+		CollateralAsset:   config.BigInt{Int: health.GreatestCollateralAsset},
+		CollateralValue:   config.BigInt{Int: health.GreatestCollateralValue},
+		LiquidationAmount: config.BigInt{Int: big.NewInt(0)},
+		CollateralAmount:  config.BigInt{Int: big.NewInt(0)},
+		BadDebt:           true,
+		UserUpdatedAt:     u.UpdatedAt,
+		UpdatedAt:         time.Now(),
+	}
+
+	// a position without any collateral cannot be liquidated, it is reported as bad debt only
+	if health.GreatestCollateralAsset == nil {
+		return candidate, true
+	}
+
+	collateralConfig := snapshot.assets.Config(health.GreatestCollateralAsset.String())
+	candidate.BadDebt = health.IsBadDebt(collateralConfig.LiquidationBonus, snapshot.sdkCfg.MasterParams.AssetLiquidationBonusScale)
+
+	_, liquidationAmount, collateralAmount, ok := snapshot.service.CalculateLiquidationData(user, snapshot.assets, snapshot.prices)
+	if ok {
+		candidate.LiquidationAmount = config.BigInt{Int: liquidationAmount}
+		candidate.CollateralAmount = config.BigInt{Int: collateralAmount}
+	}
+
+	return candidate, true
+}
+
+// healthFactor is the ratio of the liquidation limit to the debt, a position is liquidatable below 1
+func healthFactor(health *sdkPrincipal.Health) float64 {
+	if health.TotalDebt.Sign() == 0 {
+		return 0
+	}
+	hf, _ := new(big.Rat).SetFrac(health.TotalLimit, health.TotalDebt).Float64()
+	return hf
+}
+
+// rankCandidates orders positions from the least healthy one, the biggest loan goes first on equal health
+func rankCandidates(candidates []config.OnchainLiquidationCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].HealthFactor != candidates[j].HealthFactor {
+			return candidates[i].HealthFactor < candidates[j].HealthFactor
+		}
+		return candidates[i].LoanValue.Cmp(candidates[j].LoanValue.Int) == 1
+	})
+
+	for i := range candidates {
+		candidates[i].Rank = i + 1
+	}
+}
+
+func publish(candidates []config.OnchainLiquidationCandidate) error {
+	db, _ := config.GetDBInstance()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&config.OnchainLiquidationCandidate{}).Error; err != nil {
+			return err
+		}
+		if len(candidates) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(candidates, 1000).Error
+	})
+	if err != nil {
+		return fmt.Errorf("error per saving liquidation candidates: %w", err)
+	}
+
+	select {
+	case <-Candidates:
+	default:
+	}
+	Candidates <- candidates
+
+	slog.Info("liquidation candidates published", "candidates", len(candidates))
+	return nil
+}
@@ -0,0 +1,75 @@
+package liquidator
+
+import (
+	"math/big"
+	"testing"
+
+	sdkPrincipal "github.com/evaafi/evaa-go-sdk/principal"
+	"github.com/evaafi/go-indexer/config"
+)
+
+func TestHealthFactor(t *testing.T) {
+	cases := []struct {
+		name  string
+		limit int64
+		debt  int64
+		want  float64
+	}{
+		{name: "zero debt", limit: 100, debt: 0, want: 0},
+		{name: "zero limit", limit: 0, debt: 100, want: 0},
+		{name: "liquidatable", limit: 90, debt: 100, want: 0.9},
+		{name: "at the limit", limit: 100, debt: 100, want: 1},
+		{name: "healthy", limit: 300, debt: 100, want: 3},
+	}
+	for _, c := range cases {
+		health := &sdkPrincipal.Health{TotalLimit: big.NewInt(c.limit), TotalDebt: big.NewInt(c.debt)}
+		if got := healthFactor(health); got != c.want {
+			t.Errorf("%s: want %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestRankCandidates(t *testing.T) {
+	candidate := func(contract string, hf float64, loan int64) config.OnchainLiquidationCandidate {
+		return config.OnchainLiquidationCandidate{ContractAddress: contract, HealthFactor: hf, LoanValue: config.BigInt{Int: big.NewInt(loan)}}
+	}
+
+	cases := []struct {
+		name       string
+		candidates []config.OnchainLiquidationCandidate
+		want       []string
+	}{
+		{name: "empty"},
+		{
+			name:       "by health",
+			candidates: []config.OnchainLiquidationCandidate{candidate("a", 0.9, 10), candidate("b", 0.5, 10), candidate("c", 0.7, 10)},
+			want:       []string{"b", "c", "a"},
+		},
+		{
+			name:       "tie by loan value",
+			candidates: []config.OnchainLiquidationCandidate{candidate("a", 0.8, 10), candidate("b", 0.8, 30), candidate("c", 0.8, 20)},
+			want:       []string{"b", "c", "a"},
+		},
+		{
+			name:       "full tie keeps order",
+			candidates: []config.OnchainLiquidationCandidate{candidate("a", 0.8, 10), candidate("b", 0.8, 10), candidate("c", 0.1, 10)},
+			want:       []string{"c", "a", "b"},
+		},
+		{
+			name:       "zero debt health",
+			candidates: []config.OnchainLiquidationCandidate{candidate("a", 0.5, 10), candidate("b", 0, 0)},
+			want:       []string{"b", "a"},
+		},
+	}
+	for _, c := range cases {
+		rankCandidates(c.candidates)
+		if len(c.candidates) != len(c.want) {
+			t.Fatalf("%s: want %d candidates, got %d", c.name, len(c.want), len(c.candidates))
+		}
+		for i, got := range c.candidates {
+			if got.ContractAddress != c.want[i] || got.Rank != i+1 {
+				t.Errorf("%s: want %s ranked %d, got %s ranked %d", c.name, c.want[i], i+1, got.ContractAddress, got.Rank)
+			}
+		}
+	}
+}
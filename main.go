@@ -2,80 +2,145 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"github.com/evaafi/go-indexer/config"
 	"github.com/evaafi/go-indexer/indexer"
+	"github.com/evaafi/go-indexer/migrations"
+	"github.com/evaafi/go-indexer/tracing"
+	"gorm.io/gorm"
 )
 
-func main() {
-	cfg, err := config.LoadConfig("config.yaml")
-	config.CFG = cfg
+// tracingShutdownTimeout is how long buffered spans are exported for when the process exits
+const tracingShutdownTimeout = 5 * time.Second
+
+var tables = []interface{}{
+	&config.OnchainUser{},
+	&config.OnchainLog{},
+	&config.OnchainSyncState{},
+	&config.OnchainLiquidationCandidate{},
+	&config.OnchainStateDiscrepancy{},
+	&config.OnchainUpdateQueueItem{},
+	&config.OnchainUpdateDeadLetter{},
+	&config.OnchainUnparsedLog{},
+	&config.OnchainBackfillState{},
+}
 
-	if err != nil {
-		panic(fmt.Sprintf("Cant connect to database: %v", err))
-	}
+type command struct {
+	name  string
+	usage string
+	run   func(db *gorm.DB, cfg config.Config, args []string) error
+}
 
-	db, err := config.GetDBInstance()
-	if err != nil {
-		panic(fmt.Sprintf("Cant create database istance: %v", err))
-	}
+// commands are selected by the first argument, run is used when no command is given
+var commands = []command{
+	{"run", "run", runIndexer},
+	{"migrate", "migrate [up|down|partition-logs|status] [-steps n] [-dry-run]", runMigrate},
+	{"backfill", "backfill -pool name[,name]|all [-from utime] [-to utime] [-from-lt lt] [-to-lt lt] [-restart] | backfill status", runBackfill},
+	{"refresh-user", "refresh-user <wallet> -pool name [-subaccount id]", runRefreshUser},
+	{"queue", "queue dump|load [-file path] | queue drain", runQueue},
+	{"state", "state show | state set-cursor -pool name [-lt lt -hash hash] [-utime utime]", runState},
+	{"dead-letters", "dead-letters list|replay [-pool name] [-contract address]", runDeadLetters},
+	{"reparse", "reparse [-pool name] [-opcode 0x16] [-from utime] [-to utime]", runReparse},
+}
 
-	tables := []interface{}{
-		&config.OnchainUser{},
-		&config.OnchainLog{},
-		&config.OnchainSyncState{},
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: go-indexer [--config path] [command] [flags]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\n", c.usage)
 	}
+	fmt.Fprintf(flag.CommandLine.Output(), "\nflags:\n")
+	flag.PrintDefaults()
+}
 
-	if cfg.MigrateOnStart {
-		for _, table := range tables {
-			if err := db.AutoMigrate(table); err != nil {
-				panic(fmt.Sprintf("Migration error: %v", err))
-			}
-		}
+func main() {
+	configPath := flag.String("config", "config.yaml", "path to the config file")
+	flag.Usage = usage
+	flag.Parse()
+
+	name, args := "run", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
 	}
 
-	if cfg.ForceResyncOnEveryStart {
-		fmt.Println("Force resync enabled, truncating all indexing tables...")
-		for _, table := range tables {
-			if err := db.Exec(fmt.Sprintf("TRUNCATE TABLE %s RESTART IDENTITY CASCADE;", config.GetTableName(db, table))).Error; err != nil {
-				panic(fmt.Sprintf("Failed to truncate table: %v", err))
-			}
+	var selected *command
+	for i := range commands {
+		if commands[i].name == name {
+			selected = &commands[i]
 		}
-		fmt.Println("All tables truncated successfully.")
 	}
+	if selected == nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	config.CFG = cfg
 
-	config.EnsureInitialIdxSyncStateData(db)
+	if err != nil {
+		panic(fmt.Sprintf("Cant load config %s: %v", *configPath, err))
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	if err := config.SetupLogging(cfg, os.Stdout); err != nil {
+		panic(fmt.Sprintf("Cant set up logging: %v", err))
+	}
 
-	fmt.Println("Start indexing...")
-	go indexer.RunIndexer(ctx, cfg)
+	if err := indexer.ValidatePools(cfg.Pools); err != nil {
+		panic(fmt.Sprintf("Invalid pools: %v", err))
+	}
+	config.Pools = cfg.Pools
 
-	if cfg.Mode == config.ModeLiquidator {
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		panic(fmt.Sprintf("Cant set up tracing: %v", err))
+	}
 
+	db, err := config.GetDBInstance()
+	if err != nil {
+		panic(fmt.Sprintf("Cant create database istance: %v", err))
 	}
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	<-sigs
-	log.Println("Received termination signal, stopping application...")
+	// migrate manages the schema itself, other commands refuse to run on an outdated one
+	if selected.name != "migrate" {
+		err = checkSchema(db, cfg)
+	}
+	if err == nil {
+		err = selected.run(db, cfg, args)
+	}
 
-	close(indexer.Shutdown)
+	// spans still buffered are exported before exiting
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("error per exporting spans", "err", err)
+	}
+	cancel()
 
-	indexer.WG.Wait()
-	fmt.Println("Saving queue")
-	err = indexer.SaveQueue()
 	if err != nil {
-		fmt.Printf("Error per saving queue: %s\n", err)
+		slog.Error("command failed", "command", selected.name, "err", err)
+		os.Exit(1)
 	}
-	cancel()
+}
 
-	time.Sleep(3 * time.Second)
+// checkSchema applies pending migrations when migrateOnStart is set, otherwise it fails while
+// any migration is pending.
+func checkSchema(db *gorm.DB, cfg config.Config) error {
+	pending, err := migrations.Check(db)
+	if err != nil {
+		return fmt.Errorf("migration error: %w", err)
+	}
+	if pending == 0 {
+		return nil
+	}
+	if !cfg.MigrateOnStart {
+		return fmt.Errorf("%d database migrations are not applied, run the migrate command or set migrateOnStart", pending)
+	}
+	if _, err := migrations.Up(db, migrations.Options{}); err != nil {
+		return fmt.Errorf("migration error: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,157 @@
+// Package metrics holds the Prometheus metrics of the indexer, they are served by Handler.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "evaa_indexer"
+
+// Outcomes of user updates and data source requests
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+)
+
+var (
+	TransactionsIndexed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transactions_total",
+		Help:      "Pool transactions processed by the indexer.",
+	}, []string{"pool"})
+
+	LogsIndexed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logs_total",
+		Help:      "Pool logs stored by the indexer.",
+	}, []string{"pool", "tx_type"})
+
+	ParseFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "parse_failures_total",
+		Help:      "Pool logs which could not be decoded, by opcode.",
+	}, []string{"pool", "op_code"})
+
+	UpdateQueueItems = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "update_queue_items",
+		Help:      "Users in the update queue, due ones and ones scheduled later.",
+	}, []string{"state"})
+
+	UpdatesInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "updates_in_flight",
+		Help:      "Users claimed from the update queue and being updated.",
+	})
+
+	UserUpdateDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "user_update_duration_seconds",
+		Help:      "Time to read and store the state of a user, by outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"pool", "outcome"})
+
+	UserUpdateRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "user_update_retries_total",
+		Help:      "Failed user updates requeued to be retried.",
+	}, []string{"pool"})
+
+	UserUpdateDeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "user_update_dead_letters_total",
+		Help:      "Failed user updates moved to dead letters.",
+	}, []string{"pool"})
+
+	SourceRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "source_request_duration_seconds",
+		Help:      "Requests to data sources, by source, method and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"source", "method", "outcome"})
+
+	SourceRequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "source_request_errors_total",
+		Help:      "Failed requests to data sources, by source and method.",
+	}, []string{"source", "method"})
+
+	ReindexRunning = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reindex_running",
+		Help:      "1 while the reindex scheduler enqueues all users.",
+	})
+
+	ReindexEnqueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reindex_enqueued_users",
+		Help:      "Users enqueued by the current or the last reindex run.",
+	})
+
+	ReindexFinished = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reindex_last_finished_timestamp_seconds",
+		Help:      "Unix time the last reindex run finished.",
+	})
+)
+
+// cursors reports the lag of pool cursors at the time of the scrape, so a stuck pool keeps lagging.
+type cursors struct {
+	mu    sync.Mutex
+	utime map[string]int64
+	desc  *prometheus.Desc
+}
+
+var poolCursors = &cursors{
+	utime: make(map[string]int64),
+	desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "cursor_lag_seconds"),
+		"Seconds between now and the time of the last transaction the pool cursor is at.", []string{"pool"}, nil),
+}
+
+func init() {
+	prometheus.MustRegister(poolCursors)
+}
+
+func (c *cursors) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *cursors) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().Unix()
+	for pool, utime := range c.utime {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(now-utime), pool)
+	}
+}
+
+// SetCursor records the time of the last transaction the cursor of the pool is at.
+func SetCursor(pool string, utime int64) {
+	poolCursors.mu.Lock()
+	defer poolCursors.mu.Unlock()
+	poolCursors.utime[pool] = utime
+}
+
+// CursorLag returns how far the cursor of the pool is behind now, ok is false until it is recorded.
+func CursorLag(pool string) (lag time.Duration, ok bool) {
+	poolCursors.mu.Lock()
+	defer poolCursors.mu.Unlock()
+
+	utime, ok := poolCursors.utime[pool]
+	if !ok {
+		return 0, false
+	}
+	return time.Since(time.Unix(utime, 0)), true
+}
+
+// Handler serves the metrics in the Prometheus format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursorLag(t *testing.T) {
+	if _, ok := CursorLag("lag-test"); ok {
+		t.Fatal("want no lag before the cursor is recorded")
+	}
+
+	SetCursor("lag-test", time.Now().Add(-time.Minute).Unix())
+	lag, ok := CursorLag("lag-test")
+	if !ok || lag < time.Minute || lag > time.Minute+5*time.Second {
+		t.Errorf("lag = %v, %v, want about a minute", lag, ok)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	SetCursor("handler-test", time.Now().Add(-time.Hour).Unix())
+	TransactionsIndexed.WithLabelValues("handler-test").Add(3)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	for _, want := range []string{
+		`evaa_indexer_cursor_lag_seconds{pool="handler-test"} 360`,
+		`evaa_indexer_transactions_total{pool="handler-test"} 3`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS onchain_sync_states;
+DROP TABLE IF EXISTS onchain_logs;
+DROP TABLE IF EXISTS onchain_users;
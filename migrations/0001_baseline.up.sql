@@ -0,0 +1,49 @@
+-- tables of the first release, created by AutoMigrate before versioned migrations were added,
+-- so every statement is a no-op on such databases
+CREATE TABLE IF NOT EXISTS onchain_users (
+    wallet_address   text,
+    pool             text,
+    subaccount_id    smallint DEFAULT 0,
+    contract_address text NOT NULL,
+    code_version     bigint NOT NULL,
+    created_at       timestamptz NOT NULL,
+    updated_at       timestamptz NOT NULL,
+    state            numeric NOT NULL,
+    principals       jsonb NOT NULL DEFAULT '{}',
+    PRIMARY KEY (wallet_address, pool, subaccount_id, contract_address),
+    CONSTRAINT uni_onchain_users_contract_address UNIQUE (contract_address)
+);
+
+CREATE TABLE IF NOT EXISTS onchain_logs (
+    hash                                   text,
+    pool                                   text,
+    utime                                  bigint NOT NULL,
+    tx_type                                text NOT NULL,
+    tx_sub_type                            text,
+    sender_address                         text NOT NULL,
+    user_address                           text NOT NULL,
+    subaccount_id                          smallint NOT NULL DEFAULT 0,
+    attached_asset_address                 numeric,
+    attached_asset_amount                  numeric,
+    attached_asset_principal               numeric,
+    attached_asset_total_supply_principal  numeric,
+    attached_asset_total_borrow_principal  numeric,
+    attached_asset_s_rate                  numeric,
+    attached_asset_b_rate                  numeric,
+    redeemed_asset_address                 numeric,
+    redeemed_asset_amount                  numeric,
+    redeemed_asset_principal               numeric,
+    redeemed_asset_total_supply_principal  numeric,
+    redeemed_asset_total_borrow_principal  numeric,
+    redeemed_asset_s_rate                  numeric,
+    redeemed_asset_b_rate                  numeric,
+    created_at                             timestamptz DEFAULT now(),
+    PRIMARY KEY (hash, pool)
+);
+
+CREATE TABLE IF NOT EXISTS onchain_sync_states (
+    pool       text,
+    last_lt    bigint,
+    last_utime bigint,
+    PRIMARY KEY (pool)
+);
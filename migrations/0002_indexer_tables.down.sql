@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS onchain_backfill_states;
+DROP TABLE IF EXISTS onchain_unparsed_logs;
+DROP TABLE IF EXISTS onchain_update_dead_letters;
+DROP TABLE IF EXISTS onchain_update_queue_items;
+DROP TABLE IF EXISTS onchain_state_discrepancies;
+DROP TABLE IF EXISTS onchain_liquidation_candidates;
+
+ALTER TABLE onchain_logs
+    DROP COLUMN IF EXISTS body,
+    DROP COLUMN IF EXISTS msg_index,
+    DROP COLUMN IF EXISTS lt,
+    DROP COLUMN IF EXISTS log_version;
+
+ALTER TABLE onchain_sync_states DROP COLUMN IF EXISTS last_hash;
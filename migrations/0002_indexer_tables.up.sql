@@ -0,0 +1,109 @@
+-- sync cursor by (lt, hash)
+ALTER TABLE onchain_sync_states ADD COLUMN IF NOT EXISTS last_hash text;
+
+-- versioned logs with their raw bodies
+ALTER TABLE onchain_logs
+    ADD COLUMN IF NOT EXISTS log_version bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS lt bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS msg_index bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS body text;
+
+CREATE TABLE IF NOT EXISTS onchain_liquidation_candidates (
+    wallet_address     text,
+    pool               text,
+    subaccount_id      smallint DEFAULT 0,
+    contract_address   text NOT NULL,
+    rank               bigint NOT NULL,
+    health_factor      decimal NOT NULL,
+    total_supply       numeric,
+    total_debt         numeric,
+    total_limit        numeric,
+    loan_asset         numeric,
+    loan_value         numeric,
+    collateral_asset   numeric,
+    collateral_value   numeric,
+    liquidation_amount numeric,
+    collateral_amount  numeric,
+    bad_debt           boolean NOT NULL,
+    user_updated_at    timestamptz NOT NULL,
+    updated_at         timestamptz NOT NULL,
+    PRIMARY KEY (wallet_address, pool, subaccount_id)
+);
+
+CREATE TABLE IF NOT EXISTS onchain_state_discrepancies (
+    id               bigserial,
+    contract_address text NOT NULL,
+    first_source     text NOT NULL,
+    first_hash       text NOT NULL,
+    first_data       text NOT NULL,
+    second_source    text NOT NULL,
+    second_hash      text NOT NULL,
+    second_data      text NOT NULL,
+    created_at       timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_onchain_state_discrepancies_contract_address
+    ON onchain_state_discrepancies (contract_address);
+
+CREATE TABLE IF NOT EXISTS onchain_update_queue_items (
+    id               bigserial,
+    pool             text NOT NULL,
+    contract_address text NOT NULL,
+    user_address     text NOT NULL,
+    subaccount_id    smallint NOT NULL DEFAULT 0,
+    tx_utime         bigint NOT NULL,
+    attempts         bigint NOT NULL DEFAULT 0,
+    next_run_at      timestamptz NOT NULL,
+    last_error       text,
+    version          bigint NOT NULL DEFAULT 0,
+    created_at       timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_update_queue_contract ON onchain_update_queue_items (pool, contract_address);
+CREATE INDEX IF NOT EXISTS idx_onchain_update_queue_items_next_run_at ON onchain_update_queue_items (next_run_at);
+
+CREATE TABLE IF NOT EXISTS onchain_update_dead_letters (
+    id               bigserial,
+    pool             text NOT NULL,
+    contract_address text NOT NULL,
+    user_address     text NOT NULL,
+    subaccount_id    smallint NOT NULL DEFAULT 0,
+    tx_utime         bigint NOT NULL,
+    attempts         bigint NOT NULL,
+    last_error       text NOT NULL,
+    created_at       timestamptz NOT NULL,
+    failed_at        timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_onchain_update_dead_letters_pool ON onchain_update_dead_letters (pool);
+
+CREATE TABLE IF NOT EXISTS onchain_unparsed_logs (
+    hash       text,
+    pool       text,
+    msg_index  bigint,
+    lt         bigint NOT NULL,
+    utime      bigint NOT NULL,
+    op_code    bigint,
+    body       text NOT NULL,
+    error      text NOT NULL,
+    created_at timestamptz NOT NULL,
+    PRIMARY KEY (hash, pool, msg_index)
+);
+CREATE INDEX IF NOT EXISTS idx_onchain_unparsed_logs_op_code ON onchain_unparsed_logs (op_code);
+
+CREATE TABLE IF NOT EXISTS onchain_backfill_states (
+    pool         text,
+    from_utime   bigint NOT NULL,
+    to_utime     bigint NOT NULL,
+    from_lt      bigint NOT NULL,
+    to_lt        bigint NOT NULL,
+    last_lt      bigint NOT NULL,
+    last_hash    text NOT NULL,
+    last_utime   bigint NOT NULL,
+    transactions bigint NOT NULL,
+    logs         bigint NOT NULL,
+    started_at   timestamptz NOT NULL,
+    updated_at   timestamptz NOT NULL,
+    finished_at  timestamptz,
+    PRIMARY KEY (pool)
+);
@@ -0,0 +1,11 @@
+-- migrate:no-transaction
+CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS onchain_logs_hash_pool ON onchain_logs (hash, pool);
+
+ALTER TABLE onchain_logs
+    DROP CONSTRAINT onchain_logs_pkey,
+    ADD CONSTRAINT onchain_logs_pkey PRIMARY KEY USING INDEX onchain_logs_hash_pool;
+
+DROP INDEX CONCURRENTLY IF EXISTS idx_onchain_logs_redeemed_asset;
+DROP INDEX CONCURRENTLY IF EXISTS idx_onchain_logs_attached_asset;
+DROP INDEX CONCURRENTLY IF EXISTS idx_onchain_logs_pool_utime;
+DROP INDEX CONCURRENTLY IF EXISTS idx_onchain_logs_user_utime;
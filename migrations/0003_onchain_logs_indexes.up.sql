@@ -0,0 +1,23 @@
+-- migrate:no-transaction
+-- indexes are built without blocking log inserts, a build interrupted by an error leaves an INVALID
+-- index behind which must be dropped before the migration is run again
+
+-- users by their latest activity, see enqueueAllUsersGradually, and logs of a user by time
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_onchain_logs_user_utime
+    ON onchain_logs (pool, user_address, subaccount_id, utime);
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_onchain_logs_pool_utime ON onchain_logs (pool, utime);
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_onchain_logs_attached_asset
+    ON onchain_logs (pool, attached_asset_address, utime);
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_onchain_logs_redeemed_asset
+    ON onchain_logs (pool, redeemed_asset_address, utime);
+
+-- utime joins the primary key, a partitioned table needs the partition key in its unique constraints;
+-- the new key is built concurrently and swapped in with a short lock
+CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS onchain_logs_hash_pool_utime ON onchain_logs (hash, pool, utime);
+
+ALTER TABLE onchain_logs
+    DROP CONSTRAINT onchain_logs_pkey,
+    ADD CONSTRAINT onchain_logs_pkey PRIMARY KEY USING INDEX onchain_logs_hash_pool_utime;
@@ -0,0 +1,10 @@
+-- only the first log of every transaction fits the old key
+DELETE FROM onchain_logs l
+USING onchain_logs o
+WHERE l.hash = o.hash AND l.pool = o.pool AND l.utime = o.utime AND l.msg_index > o.msg_index;
+
+ALTER TABLE onchain_logs
+    DROP CONSTRAINT onchain_logs_pkey,
+    ADD CONSTRAINT onchain_logs_pkey PRIMARY KEY (hash, pool, utime);
+
+ALTER TABLE onchain_logs DROP COLUMN legacy;
@@ -0,0 +1,12 @@
+-- msg_index joins the primary key, so every log of a transaction emitting several of them is kept.
+-- Logs indexed before msg_index was added have 0 and neither lt nor body, only one log per transaction
+-- was stored for them. They are flagged as legacy and replaced when their transaction is indexed again.
+ALTER TABLE onchain_logs ADD COLUMN legacy boolean NOT NULL DEFAULT false;
+
+UPDATE onchain_logs SET legacy = true WHERE body IS NULL;
+
+-- The key is rebuilt under a lock blocking onchain_logs until it is done: a partitioned table
+-- cannot build a unique index concurrently.
+ALTER TABLE onchain_logs
+    DROP CONSTRAINT onchain_logs_pkey,
+    ADD CONSTRAINT onchain_logs_pkey PRIMARY KEY (hash, pool, msg_index, utime);
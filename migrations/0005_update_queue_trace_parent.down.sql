@@ -0,0 +1,2 @@
+ALTER TABLE onchain_update_queue_items
+    DROP COLUMN trace_parent;
@@ -0,0 +1,3 @@
+-- trace_parent is the W3C traceparent of the log which enqueued the user, the user refresh links to it.
+ALTER TABLE onchain_update_queue_items
+    ADD COLUMN trace_parent text NOT NULL DEFAULT '';
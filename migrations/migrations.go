@@ -0,0 +1,340 @@
+// Package migrations keeps the database schema in numbered SQL scripts embedded into the binary.
+// Every migration is a pair of NNNN_name.up.sql and NNNN_name.down.sql files, applied versions
+// are recorded in the schema_migrations table.
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// ErrSchemaTooNew is returned when the database has migrations the binary does not know,
+// the binary is older than the schema and must not write to it.
+var ErrSchemaTooNew = errors.New("database schema is newer than the binary")
+
+// lockID serializes migrations of indexer replicas started at once
+const lockID = 7231457018
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// noTransaction starts scripts which cannot run in a transaction, e.g. CREATE INDEX CONCURRENTLY
+const noTransaction = "-- migrate:no-transaction"
+
+// Migration is a numbered schema change, migrations are applied in the order of versions.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// SchemaMigration is an applied migration.
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false;column:version"`
+	Name      string    `gorm:"column:name;not null"`
+	AppliedAt time.Time `gorm:"column:applied_at;not null"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Options control how migrations are run.
+type Options struct {
+	// DryRun prints the SQL of the migrations to Out (stdout by default) instead of running it
+	DryRun bool
+	Out    io.Writer
+}
+
+func (o Options) out() io.Writer {
+	if o.Out == nil {
+		return os.Stdout
+	}
+	return o.Out
+}
+
+// All returns the embedded migrations ordered by version.
+func All() ([]Migration, error) {
+	return load(files)
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, name := range names {
+		match := fileName.FindStringSubmatch(path.Base(name))
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		version, _ := strconv.Atoi(match[1])
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %s has no up or down script", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Applied returns the migrations applied to the database ordered by version.
+func Applied(db *gorm.DB) ([]SchemaMigration, error) {
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return nil, nil
+	}
+
+	var applied []SchemaMigration
+	if err := db.Order("version").Find(&applied).Error; err != nil {
+		return nil, fmt.Errorf("error per reading schema migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// Pending returns the migrations not applied to the database yet. It fails with ErrSchemaTooNew
+// when the database has a migration which is not embedded into the binary.
+func Pending(db *gorm.DB) ([]Migration, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := Applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+	}
+	done := make(map[int]bool, len(applied))
+	for _, a := range applied {
+		if !known[a.Version] {
+			return nil, fmt.Errorf("%w: migration %04d_%s is unknown", ErrSchemaTooNew, a.Version, a.Name)
+		}
+		done[a.Version] = true
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Check fails with ErrSchemaTooNew when the database schema is newer than the binary and
+// reports the number of migrations not applied yet.
+func Check(db *gorm.DB) (int, error) {
+	pending, err := Pending(db)
+	return len(pending), err
+}
+
+// Up applies all pending migrations, each one in its own database transaction unless it is
+// marked with the no-transaction directive.
+// It returns the applied migrations, with DryRun the ones which would be applied.
+func Up(db *gorm.DB, opts Options) ([]Migration, error) {
+	pending, err := Pending(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		for _, m := range pending {
+			fmt.Fprintf(opts.out(), "-- %s up\n%s\n", m, m.Up)
+		}
+		return pending, nil
+	}
+
+	err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    bigint PRIMARY KEY,
+    name       text NOT NULL,
+    applied_at timestamptz NOT NULL
+)`).Error
+	if err != nil {
+		return nil, fmt.Errorf("error per creating schema migrations table: %w", err)
+	}
+
+	var applied []Migration
+	for _, m := range pending {
+		isApplied := func(tx *gorm.DB) (bool, error) {
+			var count int64
+			err := tx.Model(&SchemaMigration{}).Where("version = ?", m.Version).Count(&count).Error
+			return count > 0, err
+		}
+		record := func(tx *gorm.DB) error {
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		}
+		if err := run(db, m.Up, isApplied, record); err != nil {
+			return applied, fmt.Errorf("error per applying migration %s: %w", m, err)
+		}
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+// Down reverts the last steps applied migrations in the reverse order, each one in its own
+// database transaction unless it is marked with the no-transaction directive. It returns the reverted migrations, with DryRun the ones which would be reverted.
+func Down(db *gorm.DB, steps int, opts Options) ([]Migration, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := Applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]Migration, len(migrations))
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	var reverting []Migration
+	for i := len(applied) - 1; i >= 0 && len(reverting) < steps; i-- {
+		m, ok := byVersion[applied[i].Version]
+		if !ok {
+			return nil, fmt.Errorf("%w: migration %04d_%s is unknown", ErrSchemaTooNew, applied[i].Version, applied[i].Name)
+		}
+		reverting = append(reverting, m)
+	}
+
+	if opts.DryRun {
+		for _, m := range reverting {
+			fmt.Fprintf(opts.out(), "-- %s down\n%s\n", m, m.Down)
+		}
+		return reverting, nil
+	}
+
+	var reverted []Migration
+	for _, m := range reverting {
+		isReverted := func(tx *gorm.DB) (bool, error) {
+			var count int64
+			err := tx.Model(&SchemaMigration{}).Where("version = ?", m.Version).Count(&count).Error
+			return count == 0, err
+		}
+		record := func(tx *gorm.DB) error {
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		}
+		if err := run(db, m.Down, isReverted, record); err != nil {
+			return reverted, fmt.Errorf("error per reverting migration %s: %w", m, err)
+		}
+		reverted = append(reverted, m)
+	}
+
+	return reverted, nil
+}
+
+// run executes the script and records it with record while holding the migrations lock. A script
+// already handled by another replica meanwhile is skipped, done reports it under the lock.
+//
+// Scripts starting with the no-transaction directive are run statement by statement on one connection,
+// a failed statement leaves the previous ones in place, so such scripts must be safe to run again.
+// Other scripts run in one transaction.
+func run(db *gorm.DB, script string, done func(*gorm.DB) (bool, error), record func(*gorm.DB) error) error {
+	if !strings.HasPrefix(script, noTransaction) {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockID).Error; err != nil {
+				return err
+			}
+			if skip, err := done(tx); err != nil || skip {
+				return err
+			}
+			if err := tx.Exec(script).Error; err != nil {
+				return err
+			}
+			return record(tx)
+		})
+	}
+
+	return db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", lockID).Error; err != nil {
+			return err
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", lockID)
+
+		if skip, err := done(conn); err != nil || skip {
+			return err
+		}
+		for _, statement := range splitStatements(script) {
+			if err := conn.Exec(statement).Error; err != nil {
+				return fmt.Errorf("%w\n%s", err, statement)
+			}
+		}
+		return record(conn)
+	})
+}
+
+// splitStatements splits a script by semicolons ending lines, comment lines are dropped.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+
+	return statements
+}
@@ -0,0 +1,129 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/evaafi/go-indexer/config"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_second.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+		"0001_first.up.sql":    {Data: []byte("CREATE TABLE a ();")},
+		"0001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+	}
+
+	migrations, err := load(fsys)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].String() != "0001_first" || migrations[1].Down != "DROP TABLE b;" {
+		t.Errorf("unexpected migrations %+v", migrations)
+	}
+
+	broken := []fstest.MapFS{
+		{"0001_first.up.sql": {Data: []byte("CREATE TABLE a ();")}},
+		{"first.up.sql": {Data: []byte("CREATE TABLE a ();")}},
+		{
+			"0001_first.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+			"0001_other.down.sql": {Data: []byte("DROP TABLE a;")},
+		},
+	}
+	for i, fsys := range broken {
+		if _, err := load(fsys); err == nil {
+			t.Errorf("case %d: want error", i)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := All()
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %s: want version %d, versions must have no gaps", m, i+1)
+		}
+	}
+}
+
+// TestSchemaMatchesModels applies the migrations and checks every model column exists,
+// a model field added without a migration fails here.
+func TestSchemaMatchesModels(t *testing.T) {
+	cfg, err := config.LoadConfig("../config.yaml")
+	if err != nil {
+		t.Skipf("no test config: %v", err)
+	}
+	config.CFG = cfg
+
+	db, err := config.GetDBInstance()
+	if err != nil {
+		t.Skipf("database is not available: %v", err)
+	}
+
+	if _, err := Up(db, Options{}); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	models := []interface{}{
+		&config.OnchainUser{},
+		&config.OnchainLog{},
+		&config.OnchainSyncState{},
+		&config.OnchainLiquidationCandidate{},
+		&config.OnchainStateDiscrepancy{},
+		&config.OnchainUpdateQueueItem{},
+		&config.OnchainUpdateDeadLetter{},
+		&config.OnchainUnparsedLog{},
+		&config.OnchainBackfillState{},
+	}
+	for _, model := range models {
+		stmt := db.Model(model).Statement
+		if err := stmt.Parse(model); err != nil {
+			t.Fatalf("parse %T: %v", model, err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("%s.%s has no migration", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := noTransaction + `
+-- comment
+CREATE INDEX CONCURRENTLY a
+    ON t (x);
+
+DROP INDEX CONCURRENTLY b;
+`
+	statements := splitStatements(script)
+	if len(statements) != 2 || statements[0] != "CREATE INDEX CONCURRENTLY a\n    ON t (x);" || statements[1] != "DROP INDEX CONCURRENTLY b;" {
+		t.Errorf("unexpected statements %q", statements)
+	}
+}
+
+func TestMonthlyPartitions(t *testing.T) {
+	from := time.Date(2024, 11, 15, 10, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	partitions := monthlyPartitions(from, to)
+	if len(partitions) != 3 {
+		t.Fatalf("want 3 partitions, got %+v", partitions)
+	}
+	if partitions[0].name != "onchain_logs_p202411" || partitions[2].name != "onchain_logs_p202501" {
+		t.Errorf("unexpected partition names %+v", partitions)
+	}
+	for i := 1; i < len(partitions); i++ {
+		if !partitions[i].from.Equal(partitions[i-1].to) {
+			t.Errorf("partitions %d and %d are not adjacent", i-1, i)
+		}
+	}
+	if partitions[0].from.Unix() != time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC).Unix() {
+		t.Errorf("first partition must start at the beginning of the month, got %s", partitions[0].from)
+	}
+}
@@ -0,0 +1,165 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// logsTable is partitioned by month of the log utime when converted with PartitionLogs
+const logsTable = "onchain_logs"
+
+// partitionsAhead is how many months after the current one get partitions in advance
+const partitionsAhead = 2
+
+// logIndexes are created on the partitioned table, they match migration 0003
+var logIndexes = []string{
+	"CREATE INDEX idx_onchain_logs_user_utime ON onchain_logs (pool, user_address, subaccount_id, utime)",
+	"CREATE INDEX idx_onchain_logs_pool_utime ON onchain_logs (pool, utime)",
+	"CREATE INDEX idx_onchain_logs_attached_asset ON onchain_logs (pool, attached_asset_address, utime)",
+	"CREATE INDEX idx_onchain_logs_redeemed_asset ON onchain_logs (pool, redeemed_asset_address, utime)",
+}
+
+// partitionedCheckInterval is how long an unpartitioned onchain_logs is not checked again, so a table
+// converted by another process gets partitions of new months without a restart
+const partitionedCheckInterval = time.Minute
+
+// createdPartitions caches months which already have a partition, by the partition name
+var createdPartitions sync.Map
+
+// unpartitionedUntil is the unix time until which onchain_logs is known to be unpartitioned
+var unpartitionedUntil atomic.Int64
+
+type partition struct {
+	name     string
+	from, to time.Time
+}
+
+func (p partition) create(table string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM (%d) TO (%d)",
+		p.name, table, p.from.Unix(), p.to.Unix())
+}
+
+// monthlyPartitions returns partitions of the months from the month of from to the month of to inclusive.
+func monthlyPartitions(from, to time.Time) []partition {
+	month := time.Date(from.UTC().Year(), from.UTC().Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var partitions []partition
+	for !month.After(to.UTC()) {
+		next := month.AddDate(0, 1, 0)
+		partitions = append(partitions, partition{
+			name: fmt.Sprintf("%s_p%04d%02d", logsTable, month.Year(), int(month.Month())),
+			from: month,
+			to:   next,
+		})
+		month = next
+	}
+	return partitions
+}
+
+// LogsPartitioned reports whether onchain_logs is a partitioned table.
+func LogsPartitioned(db *gorm.DB) (bool, error) {
+	var partitioned bool
+	err := db.Raw(`SELECT EXISTS (
+    SELECT 1 FROM pg_partitioned_table p JOIN pg_class c ON c.oid = p.partrelid
+    WHERE c.relname = ? AND pg_table_is_visible(c.oid)
+)`, logsTable).Scan(&partitioned).Error
+	if err != nil {
+		return false, fmt.Errorf("error per checking logs partitioning: %w", err)
+	}
+	return partitioned, nil
+}
+
+// PartitionLogs converts onchain_logs into a table partitioned by month of utime. Partitions are created
+// from the month of the first log up to partitionsAhead months after the current one, logs are copied,
+// the old table is dropped and the primary key and logIndexes are built in one transaction. Writes of
+// logs wait until the indexes are built, which is a downtime of the indexer on a large table, reads
+// are not blocked. With DryRun the SQL is printed instead.
+func PartitionLogs(db *gorm.DB, opts Options) error {
+	partitioned, err := LogsPartitioned(db)
+	if err != nil {
+		return err
+	}
+	if partitioned {
+		return fmt.Errorf("%s is already partitioned", logsTable)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		lock := fmt.Sprintf("LOCK TABLE %s IN EXCLUSIVE MODE", logsTable)
+		if err := tx.Exec(lock).Error; err != nil {
+			return err
+		}
+
+		var first *int64
+		if err := tx.Raw(fmt.Sprintf("SELECT MIN(utime) FROM %s", logsTable)).Scan(&first).Error; err != nil {
+			return err
+		}
+		from := time.Now()
+		if first != nil {
+			from = time.Unix(*first, 0)
+		}
+
+		const partitionedTable = logsTable + "_partitioned"
+		statements := []string{
+			fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS) PARTITION BY RANGE (utime)", partitionedTable, logsTable),
+		}
+		for _, p := range monthlyPartitions(from, time.Now().AddDate(0, partitionsAhead, 0)) {
+			statements = append(statements, p.create(partitionedTable))
+		}
+		statements = append(statements,
+			fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", partitionedTable, logsTable),
+			fmt.Sprintf("DROP TABLE %s", logsTable),
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", partitionedTable, logsTable),
+			fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s_pkey PRIMARY KEY (hash, pool, msg_index, utime)", logsTable, logsTable),
+		)
+		statements = append(statements, logIndexes...)
+
+		if opts.DryRun {
+			fmt.Fprintf(opts.out(), "%s;\n%s;\n", lock, strings.Join(statements, ";\n"))
+			return nil
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return fmt.Errorf("%w\n%s", err, statement)
+			}
+		}
+		unpartitionedUntil.Store(0)
+		return nil
+	})
+}
+
+// EnsureLogPartitions creates missing partitions of onchain_logs for the months between from and to
+// utime, it does nothing when the table is not partitioned. An unpartitioned table is checked again
+// after partitionedCheckInterval.
+func EnsureLogPartitions(db *gorm.DB, from, to int64) error {
+	var missing []partition
+	for _, p := range monthlyPartitions(time.Unix(from, 0), time.Unix(to, 0)) {
+		if _, ok := createdPartitions.Load(p.name); !ok {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) == 0 || time.Now().Unix() < unpartitionedUntil.Load() {
+		return nil
+	}
+
+	partitioned, err := LogsPartitioned(db)
+	if err != nil {
+		return err
+	}
+	if !partitioned {
+		unpartitionedUntil.Store(time.Now().Add(partitionedCheckInterval).Unix())
+		return nil
+	}
+
+	for _, p := range missing {
+		if err := db.Exec(p.create(logsTable)).Error; err != nil {
+			return fmt.Errorf("error per creating partition %s: %w", p.name, err)
+		}
+		createdPartitions.Store(p.name, true)
+	}
+	return nil
+}
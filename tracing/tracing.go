@@ -0,0 +1,113 @@
+// Package tracing sets up OpenTelemetry tracing of the indexer. Spans are dropped by the no-op
+// tracer provider unless an exporter is configured.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/evaafi/go-indexer/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/evaafi/go-indexer"
+
+// defaultServiceName is the service.name of exported spans
+const defaultServiceName = "go-indexer"
+
+// Setup installs the tracer provider configured in cfg, it returns the function flushing and
+// stopping it. Without tracingExporter spans are not recorded.
+func Setup(ctx context.Context, cfg config.Config) (func(context.Context) error, error) {
+	switch cfg.TracingExporter {
+	case config.TracingExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case config.TracingExporterOTLP:
+	default:
+		return nil, fmt.Errorf("unknown tracingExporter %q", cfg.TracingExporter)
+	}
+
+	var opts []otlptracehttp.Option
+	if cfg.OTLPEndpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+	}
+	if cfg.OTLPInsecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(cfg.OTLPHeaders) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.OTLPHeaders))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error per creating otlp exporter: %w", err)
+	}
+
+	ratio := 1.0
+	if cfg.TracingSampleRatio > 0 {
+		ratio = cfg.TracingSampleRatio
+	}
+	serviceName := defaultServiceName
+	if cfg.TracingServiceName != "" {
+		serviceName = cfg.TracingServiceName
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span of the indexer tracer.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// End marks the span failed with the error, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Attributes spans of the same log or user are found by.
+func Pool(name string) attribute.KeyValue        { return attribute.String("evaa.pool", name) }
+func Wallet(address string) attribute.KeyValue   { return attribute.String("evaa.wallet", address) }
+func Contract(address string) attribute.KeyValue { return attribute.String("evaa.contract", address) }
+func TxHash(hash string) attribute.KeyValue      { return attribute.String("evaa.tx_hash", hash) }
+func Lt(lt int64) attribute.KeyValue             { return attribute.Int64("evaa.lt", lt) }
+
+// TraceParent returns the W3C traceparent of the span of the context, empty when the span is not recorded.
+func TraceParent(ctx context.Context) string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ""
+	}
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// LinkTo returns the options linking a span to the span of the traceparent, none for an invalid one.
+func LinkTo(traceParent string) []trace.SpanStartOption {
+	if traceParent == "" {
+		return nil
+	}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{"traceparent": traceParent})
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return nil
+	}
+	return []trace.SpanStartOption{trace.WithLinks(trace.Link{SpanContext: spanContext})}
+}
@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/evaafi/go-indexer/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.Config{})
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+
+	// spans of the default no-op provider are not propagated
+	ctx, span := Start(context.Background(), "noop")
+	if TraceParent(ctx) != "" {
+		t.Error("want no traceparent of a no-op span")
+	}
+	span.End()
+
+	if _, err := Setup(context.Background(), config.Config{TracingExporter: "zipkin"}); err == nil {
+		t.Error("want error for an unknown exporter")
+	}
+}
+
+func TestLinkTo(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	ctx, parse := Start(context.Background(), "ParseLogMessage")
+	traceParent := TraceParent(ctx)
+	End(parse, nil)
+	if traceParent == "" {
+		t.Fatal("want traceparent of a recorded span")
+	}
+
+	_, update := Start(context.Background(), "processUpdate", LinkTo(traceParent)...)
+	End(update, errors.New("state not found"))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("want 2 spans, got %d", len(spans))
+	}
+	links := spans[1].Links()
+	if len(links) != 1 || links[0].SpanContext.SpanID() != spans[0].SpanContext().SpanID() {
+		t.Errorf("want update linked to the parse span, got %+v", links)
+	}
+	if spans[1].Status().Code != codes.Error || spans[1].Status().Description != "state not found" {
+		t.Errorf("unexpected status %+v", spans[1].Status())
+	}
+
+	if LinkTo("") != nil || LinkTo("00-broken") != nil {
+		t.Error("want no links for invalid traceparents")
+	}
+}